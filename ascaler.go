@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jboss-openshift/ascaler/sources"
 	"github.com/golang/glog"
 )
 
-var argPollDuration = flag.Duration("poll_duration", 10*time.Second, "Polling duration")
+var (
+	argPollDuration    = flag.Duration("poll_duration", 10*time.Second, "Polling duration")
+	argShutdownTimeout = flag.Duration("shutdown_timeout", 20*time.Second, "How long to wait for in-flight work on SIGTERM/SIGINT")
+)
 
 func main() {
 	flag.Parse()
@@ -20,26 +26,59 @@ func main() {
 	err := doWork()
 	if err != nil {
 		glog.Error(err)
+		glog.Flush()
 		os.Exit(1)
 	}
+	glog.Flush()
 	os.Exit(0)
 }
 
 func doWork() error {
+	if sources.Simulating() {
+		return sources.Simulate(os.Stdout)
+	}
+
+	err := sources.StartStatusServer()
+	if err != nil {
+		return err
+	}
 	source, err := sources.NewSource(argPollDuration)
 	if err != nil {
 		return err
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
 	ticker := time.NewTicker(*argPollDuration)
 	defer ticker.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			glog.Infof("Shutting down")
+			return source.Close()
 		case <-ticker.C:
-			err := source.CheckData()
-			if err != nil {
+			err := source.CheckData(ctx)
+			if err != nil && ctx.Err() == nil {
 				glog.Errorf("Error while getting data: %#v", err)
 			}
 		}
 	}
-	return nil
+}
+
+// handleSignals cancels the control loop on SIGTERM or SIGINT, and forces the exit
+// if in-flight work and the state flush do not finish within the shutdown timeout.
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	glog.Infof("Got %v, stopping", sig)
+	cancel()
+
+	time.AfterFunc(*argShutdownTimeout, func() {
+		glog.Errorf("Shutdown did not finish within %v", *argShutdownTimeout)
+		glog.Flush()
+		os.Exit(1)
+	})
 }
@@ -3,14 +3,33 @@ package sources
 import (
 	"crypto/tls"
 	"crypto/x509"
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	kube_client "github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/golang/glog"
 	"io/ioutil"
 	"net/http"
 	"os"
 )
 
 type KubeClient struct {
-	client *kube_client.Client
+	client  *kube_client.Client
+	elector *LeaderElector // nil unless leader election is enabled
+}
+
+// canActuate tells if this replica may change the cluster, standbys only keep their caches warm.
+func (self *KubeClient) canActuate() bool {
+	if self.elector == nil || self.elector.IsLeader() {
+		return true
+	}
+	glog.V(1).Infof("Not the leader, skipping actuation")
+	return false
+}
+
+// Release gives up the leadership, if leader election is enabled.
+func (self *KubeClient) Release() {
+	if self.elector != nil {
+		self.elector.Release()
+	}
 }
 
 func (self *KubeClient) Pods(namespace string) kube_client.PodInterface {
@@ -18,7 +37,7 @@ func (self *KubeClient) Pods(namespace string) kube_client.PodInterface {
 }
 
 func (self *KubeClient) GetReplicas(name string) (int, error) {
-	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
+	rc, err := self.GetController(name)
 	if err != nil {
 		return 0, err
 	}
@@ -26,20 +45,37 @@ func (self *KubeClient) GetReplicas(name string) (int, error) {
 	return rc.Spec.Replicas, nil
 }
 
-func (self *KubeClient) SetReplicas(name string, replicas int) error {
+func (self *KubeClient) GetController(name string) (*kube_api.ReplicationController, error) {
+	return self.client.ReplicationControllers(*argNamespace).Get(name)
+}
+
+// SetReplicas changes the replicas of a replication controller. It tells if they were written, a standby
+// leaves them to the leader.
+func (self *KubeClient) SetReplicas(name string, replicas int) (bool, error) {
+	if !self.canActuate() {
+		return false, nil
+	}
+
 	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	rc.Spec.Replicas = replicas
 
 	_, err = self.client.ReplicationControllers(*argNamespace).Update(rc)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return true, nil
+}
+
+func (self *KubeClient) DeletePod(name string) error {
+	if !self.canActuate() {
+		return nil
+	}
+	return self.client.Pods(*argNamespace).Delete(name, nil)
 }
 
 func createTransport() (*http.Transport, error) {
@@ -101,5 +137,10 @@ func createClient(transport *http.Transport) *kube_client.Client {
 }
 
 func newKubeClient(transport *http.Transport) *KubeClient {
-	return &KubeClient{client: createClient(transport)}
+	kubeClient := &KubeClient{client: createClient(transport)}
+	if *argLeaderElect {
+		kubeClient.elector = newLeaderElector(kubeClient)
+		go kubeClient.elector.Run()
+	}
+	return kubeClient
 }
@@ -0,0 +1,159 @@
+package sources
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	kube_errors "github.com/GoogleCloudPlatform/kubernetes/pkg/api/errors"
+	"github.com/golang/glog"
+)
+
+var (
+	argConflictMode  = flag.String("conflict_mode", "refuse", "What to do with a target scaled by a HorizontalPodAutoscaler or another ascaler: refuse or recommend")
+	argConflictCheck = flag.Duration("conflict_check_interval", time.Minute, "How often to look for HorizontalPodAutoscalers and other owners of a target")
+	argOwner         = flag.String("owner", "", "Ownership id written to the ascaler/owner annotation of targets, defaults to the leader lock name")
+)
+
+// ownerAnnotation tells which ascaler instance scales a replication controller.
+const ownerAnnotation = "ascaler/owner"
+
+// autoscalerPaths are the API groups that may serve HorizontalPodAutoscalers, depending on the cluster version.
+var autoscalerPaths = []string{"/apis/autoscaling/v1", "/apis/extensions/v1beta1"}
+
+type crossVersionReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// horizontalPodAutoscalerList holds just what we need of either API version.
+type horizontalPodAutoscalerList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			ScaleRef       *crossVersionReference `json:"scaleRef"`
+			ScaleTargetRef *crossVersionReference `json:"scaleTargetRef"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+func owner() string {
+	if *argOwner != "" {
+		return *argOwner
+	}
+	return *argLeaderLock
+}
+
+func validateConflictMode() error {
+	switch *argConflictMode {
+	case "refuse", "recommend":
+		return nil
+	}
+	return fmt.Errorf("Invalid conflict_mode: %s", *argConflictMode)
+}
+
+// FindAutoscalers returns the HorizontalPodAutoscalers scaling the named replication controller.
+// Clusters without the API simply have none.
+func (self *KubeClient) FindAutoscalers(name string) ([]string, error) {
+	found := make([]string, 0)
+	for _, path := range autoscalerPaths {
+		raw, err := self.client.Get().AbsPath(path, "namespaces", *argNamespace, "horizontalpodautoscalers").DoRaw()
+		if err != nil {
+			if kube_errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		list := horizontalPodAutoscalerList{}
+		err = json.Unmarshal(raw, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, hpa := range list.Items {
+			ref := hpa.Spec.ScaleTargetRef
+			if ref == nil {
+				ref = hpa.Spec.ScaleRef
+			}
+			if ref != nil && ref.Kind == "ReplicationController" && ref.Name == name {
+				found = append(found, hpa.Metadata.Name)
+			}
+		}
+	}
+	return found, nil
+}
+
+// ClaimOwnership marks the replication controller as ours, unless somebody else already did.
+// It returns the other owner, if any.
+func (self *KubeClient) ClaimOwnership(name string) (string, error) {
+	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	current := rc.Annotations[ownerAnnotation]
+	if current == owner() {
+		return "", nil
+	}
+	if current != "" {
+		return current, nil
+	}
+	if !self.canActuate() {
+		return "", nil
+	}
+
+	if rc.Annotations == nil {
+		rc.Annotations = make(map[string]string)
+	}
+	rc.Annotations[ownerAnnotation] = owner()
+	_, err = self.client.ReplicationControllers(*argNamespace).Update(rc)
+	return "", err
+}
+
+// ConflictChecker periodically looks for anything else scaling a target.
+type ConflictChecker struct {
+	lastCheck time.Time
+	conflict  string // what else scales the target, empty if nothing
+}
+
+// Check returns what else scales the target, raising a warning whenever that changes.
+func (self *ConflictChecker) Check(client *KubeClient, name string) string {
+	if time.Since(self.lastCheck) < *argConflictCheck {
+		return self.conflict
+	}
+	self.lastCheck = time.Now()
+
+	conflicts := make([]string, 0)
+	autoscalers, err := client.FindAutoscalers(name)
+	if err != nil {
+		glog.Errorf("Error looking for autoscalers of %s: %s", name, err)
+	}
+	for _, hpa := range autoscalers {
+		conflicts = append(conflicts, fmt.Sprintf("HorizontalPodAutoscaler %s", hpa))
+	}
+	if len(conflicts) == 0 {
+		other, err := client.ClaimOwnership(name)
+		if err != nil {
+			glog.Errorf("Error claiming ownership of %s: %s", name, err)
+		}
+		if other != "" {
+			conflicts = append(conflicts, fmt.Sprintf("ascaler instance %s (%s annotation)", other, ownerAnnotation))
+		}
+	}
+
+	conflict := strings.Join(conflicts, ", ")
+	if conflict != self.conflict {
+		if conflict != "" {
+			glog.Warningf("%s is also scaled by %s", name, conflict)
+			client.RecordEvent(name, "ScalingConflict", fmt.Sprintf("Also scaled by %s, ascaler will %s", conflict, *argConflictMode))
+		} else {
+			client.RecordEvent(name, "ScalingConflictResolved", "No other autoscaler found, ascaler resumes scaling")
+		}
+		self.conflict = conflict
+	}
+	return conflict
+}
@@ -0,0 +1,138 @@
+package sources
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronExpression is a standard five field cron expression: minute, hour, day of month, month and
+// day of week. Fields take *, lists, ranges and steps, day of month also takes L for the last day.
+type CronExpression struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	lastDay  bool // L in the day of month
+
+	anyDay     bool // day of month is *
+	anyWeekday bool // day of week is *
+}
+
+func parseCron(spec string) (*CronExpression, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Cron expression %q needs 5 fields, has %v", spec, len(fields))
+	}
+
+	var err error
+	cron := &CronExpression{
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	if cron.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if cron.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	days := make([]string, 0)
+	for _, part := range strings.Split(fields[2], ",") {
+		if part == "L" {
+			cron.lastDay = true
+		} else {
+			days = append(days, part)
+		}
+	}
+	cron.days = make(map[int]bool)
+	if len(days) > 0 {
+		if cron.days, err = parseCronField(strings.Join(days, ","), 1, 31); err != nil {
+			return nil, err
+		}
+	}
+	if cron.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if cron.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if cron.weekdays[7] {
+		cron.weekdays[0] = true // both 0 and 7 are sunday
+	}
+	return cron, nil
+}
+
+// parseCronField reads a comma separated list of *, n, n-m, each optionally followed by /step.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("Invalid step in cron field %q", field)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid cron field %q", field)
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("Invalid cron field %q", field)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("Cron field %q out of range %v-%v", field, min, max)
+		}
+
+		for value := from; value <= to; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Matches tells if the expression fires at the minute of the given time, in its location.
+func (self *CronExpression) Matches(t time.Time) bool {
+	if !self.minutes[t.Minute()] || !self.hours[t.Hour()] || !self.months[int(t.Month())] {
+		return false
+	}
+
+	day := self.days[t.Day()] || (self.lastDay && t.AddDate(0, 0, 1).Day() == 1)
+	weekday := self.weekdays[int(t.Weekday())]
+	// like cron, a restricted day of month and day of week match either
+	switch {
+	case self.anyDay && self.anyWeekday:
+		return true
+	case self.anyDay:
+		return weekday
+	case self.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// FiredWithin returns when the expression last fired within the duration before now, if it did.
+func (self *CronExpression) FiredWithin(now time.Time, duration time.Duration) (time.Time, bool) {
+	for t := now.Truncate(time.Minute); now.Sub(t) < duration; t = t.Add(-time.Minute) {
+		if self.Matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,105 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argDatasources     = flag.String("datasources", "", "Comma separated datasource[=database] list whose JDBC pools count against a database connection budget")
+	argDbConnBudgets   = flag.String("db_connection_budgets", "", "Comma separated database=connections list, shared by all datasources pointing to the same database")
+	defaultMaxPoolSize = 20 // JCA default when max-pool-size is undefined
+)
+
+// ConnectionBudget caps the EAP replicas so that pods x JDBC max pool size stays within
+// the connections a database allows. Several datasources may share one database budget.
+type ConnectionBudget struct {
+	datasources map[string]string // datasource -> database
+	budgets     map[string]int    // database -> max connections
+}
+
+// parseKeyValues parses "key[=value],..." lists, using the key as value when none is given.
+func parseKeyValues(s string) map[string]string {
+	kv := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) == 2 {
+			kv[key] = strings.TrimSpace(parts[1])
+		} else {
+			kv[key] = key
+		}
+	}
+	return kv
+}
+
+func newConnectionBudget() (*ConnectionBudget, error) {
+	datasources := parseKeyValues(*argDatasources)
+	if len(datasources) == 0 {
+		return nil, nil
+	}
+
+	budgets := make(map[string]int)
+	for db, value := range parseKeyValues(*argDbConnBudgets) {
+		budget, err := strconv.Atoi(value)
+		if err != nil || budget <= 0 {
+			return nil, fmt.Errorf("Invalid connection budget for database %s: %s", db, value)
+		}
+		budgets[db] = budget
+	}
+
+	for ds, db := range datasources {
+		if _, found := budgets[db]; !found {
+			return nil, fmt.Errorf("No connection budget for database %s of datasource %s", db, ds)
+		}
+	}
+
+	return &ConnectionBudget{datasources: datasources, budgets: budgets}, nil
+}
+
+// Datasources returns the names of the datasources whose pool size we need to read.
+func (self *ConnectionBudget) Datasources() []string {
+	names := make([]string, 0, len(self.datasources))
+	for ds := range self.datasources {
+		names = append(names, ds)
+	}
+	return names
+}
+
+// Cap limits replicas to what the budgets allow, given per pod max pool sizes.
+// The returned reason is empty if no budget limited the replicas.
+func (self *ConnectionBudget) Cap(replicas int, poolSizes map[string]int) (int, string) {
+	perPod := make(map[string]int)
+	for ds, db := range self.datasources {
+		size, found := poolSizes[ds]
+		if !found {
+			continue // not read yet, cannot limit
+		}
+		perPod[db] += size
+	}
+
+	reason := ""
+	for db, connections := range perPod {
+		if connections == 0 {
+			continue
+		}
+		limit := self.budgets[db] / connections
+		if limit < 1 {
+			glog.Warningf("Database %s budget of %d connections is lower than a single pod's pools (%d)", db, self.budgets[db], connections)
+			limit = 1
+		}
+		if limit < replicas {
+			reason = fmt.Sprintf("database %s connection budget (%d connections, %d per pod)", db, self.budgets[db], connections)
+			replicas = limit
+		}
+	}
+	return replicas, reason
+}
@@ -0,0 +1,230 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kube_labels "github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/golang/glog"
+)
+
+var (
+	argDependents   = flag.String("dependents", "", "Comma separated controller=ratio list of tiers scaled along the EAP pods, e.g. backend=1/3")
+	argDependentMin = flag.String("dependent_min", "", "Comma separated controller=replicas list of dependent tier minimums, defaults to 1")
+	argDependentMax = flag.String("dependent_max", "", "Comma separated controller=replicas list of dependent tier maximums, unlimited by default")
+
+	argDependentExpressions = flag.String("dependent_expressions", "", "Semicolon separated controller=expression list of tiers scaled by an expression of the EAP replicas and metrics, e.g. cache=max(2, ceil(replicas/4))")
+)
+
+// DependentTarget is a tier whose replicas follow the EAP replicas by a ratio, or by an expression.
+type DependentTarget struct {
+	target     *ScaleTarget
+	ratio      float64
+	expression Expression    // replaces the ratio if set
+	window     time.Duration // longest avg window of the expression
+	history    []Observation // of the EAP pods, for the expression
+	min        int
+	max        int // 0 is unlimited
+}
+
+// Dependents are the tiers scaled along the EAP pods.
+type Dependents []*DependentTarget
+
+// parseRatio reads a ratio given as a fraction (1/3) or a number (0.33).
+func parseRatio(value string) (float64, error) {
+	parts := strings.SplitN(value, "/", 2)
+	ratio, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) == 2 {
+		divisor, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return 0, err
+		}
+		if divisor == 0 {
+			return 0, fmt.Errorf("Division by zero")
+		}
+		ratio /= divisor
+	}
+	if ratio < 0 {
+		return 0, fmt.Errorf("Negative ratio")
+	}
+	return ratio, nil
+}
+
+// parseExpressions reads a semicolon separated controller=expression list, expressions have commas.
+func parseExpressions(value string) (map[string]Expression, error) {
+	expressions := make(map[string]Expression)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Missing expression for controller %s", name)
+		}
+		expression, err := parseExpression(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid expression for controller %s: %s", name, err)
+		}
+		expressions[name] = expression
+	}
+	return expressions, nil
+}
+
+func parseReplicaLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for rc, replicas := range parseKeyValues(value) {
+		limit, err := strconv.Atoi(replicas)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("Invalid replicas for controller %s: %s", rc, replicas)
+		}
+		limits[rc] = limit
+	}
+	return limits, nil
+}
+
+// newDependents sets up the tiers given by the flags, sorted by name.
+func newDependents(client *KubeClient) (Dependents, error) {
+	ratios := parseKeyValues(*argDependents)
+	expressions, err := parseExpressions(*argDependentExpressions)
+	if err != nil {
+		return nil, err
+	}
+	for name := range expressions {
+		if _, found := ratios[name]; found {
+			return nil, fmt.Errorf("Controller %s has both a ratio and an expression", name)
+		}
+		ratios[name] = ""
+	}
+	mins, err := parseReplicaLimits(*argDependentMin)
+	if err != nil {
+		return nil, err
+	}
+	maxs, err := parseReplicaLimits(*argDependentMax)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(ratios))
+	for name := range ratios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dependents := make(Dependents, 0, len(names))
+	for _, name := range names {
+		dependent := &DependentTarget{expression: expressions[name]}
+		if dependent.expression != nil {
+			dependent.window = longestWindow(dependent.expression)
+		} else {
+			dependent.ratio, err = parseRatio(ratios[name])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ratio for controller %s: %s", name, err)
+			}
+		}
+
+		min, found := mins[name]
+		if !found {
+			min = 1
+		}
+		max := maxs[name]
+		if max > 0 && max < min {
+			return nil, fmt.Errorf("Maximum of controller %s is below its minimum", name)
+		}
+
+		rc, err := client.client.ReplicationControllers(*argNamespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		selector := kube_labels.SelectorFromSet(rc.Spec.Selector).String()
+
+		if dependent.expression != nil {
+			glog.Infof("Scaling %s along by an expression, between %v and %v replicas", name, min, max)
+		} else {
+			glog.Infof("Scaling %s along with a ratio of %v, between %v and %v replicas", name, dependent.ratio, min, max)
+		}
+		dependent.target = newScaleTarget(client, name, selector)
+		dependent.min, dependent.max = min, max
+		dependents = append(dependents, dependent)
+	}
+	return dependents, nil
+}
+
+// Replicas derives the tier's replicas from the primary ones and the observed load, rounding up.
+// An expression sees the primary replicas as replicas.
+func (self *DependentTarget) Replicas(primary int, observation Observation) int {
+	replicas := int(math.Ceil(float64(primary) * self.ratio))
+	if self.expression != nil {
+		observation.Replicas = primary
+		self.history = append(self.history, observation)
+		for len(self.history) > 1 && observation.At.Sub(self.history[0].At) > self.window {
+			self.history = self.history[1:]
+		}
+		value := self.expression.Eval(self.history)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			glog.Warningf("Expression of %s gave %v, keeping %v replicas", self.target.name, value, self.target.currentReplicas)
+			return self.target.currentReplicas
+		}
+		replicas = int(math.Ceil(value))
+	}
+	if replicas < self.min {
+		replicas = self.min
+	}
+	if self.max > 0 && replicas > self.max {
+		replicas = self.max
+	}
+	return replicas
+}
+
+// Decide runs the replicas derived from the primary ones past everything else that scales each tier.
+func (self Dependents) Decide(client *KubeClient, primary int, observation Observation) ([]Decision, error) {
+	decisions := make([]Decision, len(self))
+	for i, dependent := range self {
+		decision, err := dependent.target.Decide(client, dependent.Replicas(primary, observation))
+		if err != nil {
+			return nil, err
+		}
+		decisions[i] = decision
+	}
+	return decisions, nil
+}
+
+// uses tells if an expression of the tiers refers to the metric.
+func (self Dependents) uses(metric string) bool {
+	for _, dependent := range self {
+		if dependent.expression == nil {
+			continue
+		}
+		for _, name := range dependent.expression.Metrics() {
+			if name == metric {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply actuates the decisions of the tiers.
+func (self Dependents) Apply(client *KubeClient, decisions []Decision) error {
+	for i, dependent := range self {
+		decision := decisions[i]
+		if decision.Changed {
+			glog.Infof("Applying %v replicas to dependent %s", decision.Replicas, decision.Controller)
+			var err error
+			decision.Changed, err = client.SetReplicas(decision.Controller, decision.Replicas)
+			if err != nil {
+				return err
+			}
+		}
+		dependent.target.Applied(client, decision)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package sources
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
@@ -11,16 +12,18 @@ import (
 )
 
 type DmrContainer struct {
-	Pod     Pod
-	Name    string
-	Host    string
-	DmrPort int
+	Pod      Pod
+	Name     string
+	Host     string
+	DmrPort  int
+	Selector string // the selector the pod was found by
 }
 
 type DmrAttributeRequest struct {
-	Operation string `json:"operation"`
-	Name      string `json:"name"`
-	Pretty    int    `json:"json.pretty"`
+	Operation string   `json:"operation"`
+	Name      string   `json:"name"`
+	Address   []string `json:"address,omitempty"`
+	Pretty    int      `json:"json.pretty"`
 }
 
 type DmrResourceRequest struct {
@@ -67,67 +70,380 @@ type InstanceData struct {
 	Previous  int64 // previous request count
 
 	Current int64 // current request count
+
+	PreviousProcessing int64 // previous processing time, in ms
+	CurrentProcessing  int64 // current processing time, in ms
 }
 
 type RequestCountData struct {
-	pods            map[types.UID]*InstanceData // 1pod --> 1eap container, no locking/synch atm
-	currentPods     []types.UID
-	currentReplicas int // how many replicas we currently have
+	pods        map[types.UID]*InstanceData // 1pod --> 1eap container, no locking/synch atm
+	currentPods []types.UID
+	targets     []*ScaleTarget  // the replication controllers we scale
+	service     *ServiceTarget  // the service they are behind, if we scale by service
+	dependents  Dependents      // tiers scaled along them
+	forecaster  *Forecaster     // optional rate forecast
+	policy      Policy          // replicas for the observed load
+	shadows     *ShadowPolicies // evaluated next to the policy, without scaling
+	flaps       *FlapDamper     // damps scaling up and down over and over
+	safeMode    *SafeMode       // holds the replicas when the metrics cannot be trusted
+	startup     *StartupTracker // how long new pods take to serve
+	trend       RateTrend       // recent rates
+	projected   float64         // rate extrapolated one start-up time ahead
+
+	budget    *ConnectionBudget // optional database connection ceiling
+	poolSizes map[string]int    // datasource --> max pool size seen this poll
+
+	containers map[types.UID]*DmrContainer // containers seen this poll, for graceful scale down
+	sessions   map[types.UID]int           // active sessions per pod seen this poll
+
+	counts      PodCounts             // pods that are not serving yet
+	constrained bool                  // whether pods are stuck waiting for cluster capacity
+	quarantined map[types.UID]bool    // unhealthy pods left out of the rate this poll
+	scraped     map[types.UID]Pod     // pods scraped this poll
+	ready       int                   // pods that should have been scraped this poll
+	heap        map[types.UID]float64 // used / max heap of the pods scraped this poll, if needed
+	cappedBy    string                // what limited the last decision, if anything
 }
 
-func (self *RequestCountData) Calculate(client *KubeClient) error {
-	size := len(self.pods)
+func newRequestCountData(client *KubeClient, targets []*ScaleTarget, service *ServiceTarget, dependents Dependents, budget *ConnectionBudget) *RequestCountData {
+	pods := make(map[types.UID]*InstanceData)
+	var model *HoltWinters
+	var startup time.Duration
+	restored := &ControllerState{} // policy state
+	var flaps *FlapState
+	for _, target := range targets {
+		for uid, data := range target.state.Pods { // baselines of a previous ascaler, if any
+			pods[uid] = data
+		}
+		if model == nil {
+			model = target.state.Forecast
+		}
+		if startup == 0 {
+			startup = target.state.StartupTime
+		}
+		if restored.PID == nil && restored.Rules == nil && restored.Utilization == nil {
+			restored.PID, restored.Rules = target.state.PID, target.state.Rules
+			restored.Utilization = target.state.Utilization
+		}
+		if target.state.WebhookChanged.After(restored.WebhookChanged) {
+			restored.WebhookChanged = target.state.WebhookChanged
+		}
+		if flaps == nil {
+			flaps = target.state.Flap
+		}
+	}
 
-	if size == 0 {
-		// should probably not happen
-		glog.Warning("EAP size is zero!")
-		return nil
+	data := &RequestCountData{
+		pods:        pods,
+		targets:     targets,
+		service:     service,
+		dependents:  dependents,
+		startup:     newStartupTracker(startup),
+		scraped:     make(map[types.UID]Pod),
+		heap:        make(map[types.UID]float64),
+		budget:      budget,
+		poolSizes:   make(map[string]int),
+		containers:  make(map[types.UID]*DmrContainer),
+		sessions:    make(map[types.UID]int),
+		quarantined: make(map[types.UID]bool),
 	}
+	if *argForecast {
+		data.forecaster = newForecaster(data.name(), model)
+	}
+	owner := PolicyOwner{client: client, name: data.name()}
+	data.policy = newPolicy(owner, restored)
+	data.shadows = newShadowPolicies(owner)
+	data.flaps = newFlapDamper(data.name(), flaps)
+	data.safeMode = newSafeMode(data.name())
+	return data
+}
 
+func (self *RequestCountData) Calculate(ctx context.Context, client *KubeClient) error {
 	currentTime := time.Now().Unix()
 
 	// new map
 	currentPods := make(map[types.UID]*InstanceData)
 
 	sum := int64(0)
+	requests, processing, measured := int64(0), int64(0), 0
+	perPod := make([]PodObservation, 0, len(self.currentPods))
 	for _, uid := range self.currentPods {
 		data := self.pods[uid]
 		timeDiff := (currentTime - data.Timestamp)
-		if timeDiff > 0 {
+		if timeDiff > 0 && !self.quarantined[uid] {
 			podAvg := (data.Current - data.Previous) / timeDiff
 			sum += podAvg
+			self.startup.Observe(self.scraped[uid])
+			pod := PodObservation{Name: self.scraped[uid].Name, Rate: float64(podAvg), Heap: self.heap[uid]}
+			if data.Current > data.Previous && data.CurrentProcessing >= data.PreviousProcessing {
+				requests += data.Current - data.Previous
+				processing += data.CurrentProcessing - data.PreviousProcessing
+				pod.Latency = float64(data.CurrentProcessing-data.PreviousProcessing) / float64(data.Current-data.Previous)
+			}
+			perPod = append(perPod, pod)
+			measured++
 		}
 		data.Timestamp = currentTime
 		data.Previous = data.Current
 		data.Current = int64(0)
+		data.PreviousProcessing = data.CurrentProcessing
+		data.CurrentProcessing = int64(0)
 		currentPods[uid] = data
 	}
-	replicas := int(sum/int64(*eapPodRate)) + 1
-
-	// cleanup pods info
+	previousPods := self.pods
 	self.pods = currentPods // forget old pods/containers
-	self.currentPods = nil
+	self.startup.Forget(currentPods)
+	defer self.cleanup()
+
+	observation := Observation{
+		At:       time.Now(),
+		Rate:     float64(sum),
+		Pods:     measured,
+		Replicas: self.currentReplicas(),
+		PerPod:   perPod,
+
+		Annotations: self.annotations(),
+	}
+	if requests > 0 {
+		observation.Latency = float64(processing) / float64(requests)
+	}
+	for _, heap := range self.heap {
+		observation.Heap += heap / float64(len(self.heap))
+	}
+	if err := recordObservation(observation); err != nil {
+		glog.Errorf("Error recording observation: %s", err)
+	}
+
+	// partial metrics look like less load, they must not feed the policy or scale down
+	current := self.currentReplicas()
+	var replicas int
+	if self.safeMode.Check(client, self.targets, measured, self.ready, observation.At) {
+		replicas = self.safeMode.Replicas(current)
+		// keep the baselines of the pods we lost, their rate is right again once they are back
+		for uid, data := range previousPods {
+			if _, found := self.pods[uid]; !found {
+				self.pods[uid] = data
+			}
+		}
+	} else if measured == 0 {
+		glog.Warning("No EAP pods to measure, holding the replicas")
+		return nil
+	} else {
+		replicas = self.desired(client, observation)
+	}
+
+	// booting pods are capacity already on its way, only ask for more beyond them
+	if self.counts.Booting > 0 && current > 0 && replicas > current &&
+		replicas <= len(self.currentPods)+self.counts.Booting {
+		glog.Infof("Holding EAP replicas at %v, %v pods still booting", current, self.counts.Booting)
+		replicas = current
+	}
+
+	// no point asking for more pods while the previous ones did not materialize
+	if self.counts.Pending > 0 && current > 0 && replicas > current {
+		glog.Warningf("Holding EAP replicas at %v, %v pods still pending", current, self.counts.Pending)
+		replicas = current
+	}
+	self.checkConstrained(client)
+
+	self.cappedBy = ""
+	if self.budget != nil {
+		capped, reason := self.budget.Cap(replicas, self.poolSizes)
+		if capped < replicas {
+			glog.Warningf("EAP replicas %v capped to %v by %s", replicas, capped, reason)
+			replicas = capped
+			self.cappedBy = reason
+		}
+	}
 
 	glog.Infof("Current EAP replicas: %v ... [%v / %v]", replicas, sum, *eapPodRate)
 
+	shares := []int{replicas}
+	if self.service != nil {
+		targets, err := self.service.Refresh(client)
+		if err != nil {
+			return err
+		}
+		self.targets = targets
+		shares = self.service.Split(replicas, targets)
+	}
+
+	decisions := make([]Decision, len(self.targets))
+	total, moved := 0, 0
+	for i, target := range self.targets {
+		decision, err := target.Decide(client, shares[i])
+		if err != nil {
+			return err
+		}
+		decisions[i] = decision
+		total += decision.Replicas
+		if decision.Changed {
+			moved += decision.Replicas - target.currentReplicas
+		}
+	}
+	self.flaps.Observe(client, self.targets, moved, observation.At)
+	self.shadows.Report(total)
+	name := self.name()
+	flapsGauge.WithLabelValues(name).Set(float64(self.flaps.Flaps()))
+	flapDampingGauge.WithLabelValues(name).Set(float64(self.flaps.state.Level))
+
+	tiers, err := self.dependents.Decide(client, total, observation)
+	if err != nil {
+		return err
+	}
+
+	// the backend has to be there before the front sends it more, and stay until the front is gone
+	scaleUp := total > self.currentReplicas()
+	if scaleUp {
+		err = self.dependents.Apply(client, tiers)
+		if err != nil {
+			return err
+		}
+	}
+	for i, target := range self.targets {
+		err := self.apply(ctx, client, target, decisions[i])
+		if err != nil {
+			return err
+		}
+	}
+	if !scaleUp {
+		return self.dependents.Apply(client, tiers)
+	}
+
+	return nil
+}
+
+// desired runs the observation through the policy and what adjusts its replicas.
+func (self *RequestCountData) desired(client *KubeClient, observation Observation) int {
+	replicas := self.policy.Replicas(observation)
+	self.shadows.Evaluate(observation)
+
+	// new pods only serve a start-up time from now, scale for the load by then
+	lead := self.startup.Lead()
+	self.trend.Observe(observation.At, observation.Rate)
+	self.projected = self.trend.Project(lead)
+	name := self.name()
+	startupSecondsGauge.WithLabelValues(name).Set(lead.Seconds())
+	projectedRateGauge.WithLabelValues(name).Set(self.projected)
+	if projected := clampReplicas(int(self.projected/float64(*eapPodRate)) + 1); *argTrendScaling && projected > replicas {
+		glog.Infof("Scaling for the rate of %.1f projected in %v: %v replicas", self.projected, lead, projected)
+		replicas = projected
+	}
+	replicas = self.forecaster.Apply(observation.Rate, replicas, lead)
+
+	return self.flaps.Damp(client, self.targets, self.currentReplicas(), replicas, observation.At)
+}
+
+// apply actuates the decision of one target.
+func (self *RequestCountData) apply(ctx context.Context, client *KubeClient, target *ScaleTarget, decision Decision) error {
+	var err error
 	// only poke k8s if we have to change replicas size
-	if replicas != self.currentReplicas {
-		err := client.SetReplicas(*eapReplicationController, replicas)
+	if decision.Changed {
+		// the pods we saw are only all of the target's when it is the only one
+		// standbys must not suspend pods they cannot delete
+		graceful := *argGracefulScaleDown && len(self.targets) == 1 && !target.rollout.inProgress && client.canActuate()
+		if graceful && decision.Replicas < target.currentReplicas {
+			err = self.scaleDown(ctx, client, target.currentReplicas, decision)
+		} else {
+			decision.Changed, err = client.SetReplicas(decision.Controller, decision.Replicas)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
-	self.currentReplicas = replicas
+	self.saveLearned(target)
+	target.Applied(client, decision)
 
 	return nil
 }
 
+// saveLearned stores what was learned about the load in the target's state.
+func (self *RequestCountData) saveLearned(target *ScaleTarget) {
+	target.state.Pods = self.pods
+	target.state.StartupTime = self.startup.learned
+	savePolicy(self.policy, target.state)
+	flaps := self.flaps.state
+	target.state.Flap = &flaps
+	if self.forecaster != nil {
+		target.state.Forecast = self.forecaster.model
+	}
+}
+
+// annotations are the ones of the policy owner, as read on the last poll.
+func (self *RequestCountData) annotations() map[string]string {
+	if self.service != nil {
+		return self.service.annotations
+	}
+	if len(self.targets) > 0 {
+		return self.targets[0].annotations
+	}
+	return nil
+}
+
+// needsHeap tells if the policy, a shadow policy or a dependent tier uses the heap of the pods.
+func (self *RequestCountData) needsHeap() bool {
+	return policyUsesHeap(self.policy) || self.shadows.usesHeap() || self.dependents.uses("heap_used_pct")
+}
+
+func policyUsesHeap(policy Policy) bool {
+	switch policy := policy.(type) {
+	case *WebhookPolicy:
+		return true // sent per pod
+	case *ExpressionPolicy:
+		return policy.uses("heap_used_pct")
+	}
+	return false
+}
+
+// name is what the targets are known as in metrics.
+func (self *RequestCountData) name() string {
+	if self.service != nil {
+		return self.service.name
+	}
+	return *eapReplicationController
+}
+
+// currentReplicas sums the current replicas of all targets.
+func (self *RequestCountData) currentReplicas() int {
+	current := 0
+	for _, target := range self.targets {
+		current += target.currentReplicas
+	}
+	return current
+}
+
+// cleanup forgets what we saw during the poll.
+func (self *RequestCountData) cleanup() {
+	self.currentPods = nil
+	self.poolSizes = make(map[string]int)
+	self.containers = make(map[types.UID]*DmrContainer)
+	self.sessions = make(map[types.UID]int)
+	self.quarantined = make(map[types.UID]bool)
+	self.scraped = make(map[types.UID]Pod)
+	self.heap = make(map[types.UID]float64)
+}
+
 func (self *DmrContainer) GetName() string {
 	return self.Name
 }
 
-func (self *DmrContainer) CheckStats(kube *KubeSource) error {
+// CheckStats scrapes the pod. Only a failure to read its server state or request count is returned, and
+// counts against the health of the pod.
+func (self *DmrContainer) CheckStats(ctx context.Context, kube *KubeSource) error {
+	requestCountData := kube.GetData(self.Selector).(*RequestCountData)
+
+	running, err := self.checkServerState(ctx)
+	if err != nil {
+		return err
+	}
+	if !running {
+		glog.Infof("EAP server in pod %s is not running yet", self.Pod.Name)
+		requestCountData.counts.Booting++
+		requestCountData.ready-- // booting, its metrics are not missing
+		return nil
+	}
+
 	dmrRequest := DmrResourceRequest{
 		Operation:      "read-resource",
 		IncludeRuntime: true,
@@ -140,40 +456,124 @@ func (self *DmrContainer) CheckStats(kube *KubeSource) error {
 		Result: &wr,
 	}
 
-	err := self.getStats(&dmrRequest, &dmrResponse)
+	err = self.getStats(ctx, &dmrRequest, &dmrResponse)
 	if err != nil {
 		return err
 	}
 
 	rcValue := int64(wr.RequestCount.Value)
 
-	queryEntry := kube.GetData(*eapSelector)
-	if queryEntry != nil {
-		requestCountData := queryEntry.(*RequestCountData)
+	requestCountData.currentPods = append(requestCountData.currentPods, self.Pod.ID)
+	requestCountData.scraped[self.Pod.ID] = self.Pod
 
-		requestCountData.currentPods = append(requestCountData.currentPods, self.Pod.ID)
+	data := requestCountData.pods[self.Pod.ID]
+	if data != nil {
+		data.Current += rcValue
+		data.CurrentProcessing += int64(wr.ProcessingTime.Value)
+	} else {
+		// best guess, just set timestamp to "previous" poll
+		data = &InstanceData{Timestamp: time.Now().Unix() - int64(*kube.Poll_time), Current: rcValue,
+			CurrentProcessing: int64(wr.ProcessingTime.Value)}
+		requestCountData.pods[self.Pod.ID] = data
+	}
 
-		data := requestCountData.pods[self.Pod.ID]
-		if data != nil {
-			data.Current += rcValue
-		} else {
-			// best guess, just set timestamp to "previous" poll
-			data = &InstanceData{Timestamp: time.Now().Unix() - int64(*kube.Poll_time), Current: rcValue}
-			requestCountData.pods[self.Pod.ID] = data
+	// the secondary reads below do not make the pod unhealthy, its request count was read
+	if *argGracefulScaleDown {
+		if err := self.checkSessions(ctx, requestCountData); err != nil {
+			glog.Warningf("Cannot read the sessions of pod %s: %s", self.Pod.Name, err)
 		}
-	} else {
-		data := &InstanceData{Timestamp: time.Now().Unix() - int64(*kube.Poll_time), Current: rcValue}
-		requestCountDataPtr := &RequestCountData{
-			pods:        map[types.UID]*InstanceData{self.Pod.ID: data},
-			currentPods: []types.UID{self.Pod.ID},
+	}
+
+	if requestCountData.needsHeap() {
+		if err := self.checkHeap(ctx, requestCountData); err != nil {
+			glog.Warningf("Cannot read the heap of pod %s: %s", self.Pod.Name, err)
+		}
+	}
+
+	if requestCountData.budget != nil {
+		if err := self.checkPoolSizes(ctx, requestCountData); err != nil {
+			glog.Warningf("Cannot read the pool sizes of pod %s: %s", self.Pod.Name, err)
 		}
-		kube.PutData(*eapSelector, requestCountDataPtr)
 	}
 
 	return nil
 }
 
-func (self *DmrContainer) getStats(request interface{}, result interface{}) error {
+// checkPoolSizes reads the JDBC max pool size of every budgeted datasource.
+func (self *DmrContainer) checkPoolSizes(ctx context.Context, requestCountData *RequestCountData) error {
+	for _, ds := range requestCountData.budget.Datasources() {
+		dmrRequest := DmrAttributeRequest{
+			Operation: "read-attribute",
+			Name:      "max-pool-size",
+			Address:   []string{"subsystem", "datasources", "data-source", ds},
+			Pretty:    1,
+		}
+
+		size := StringInt{}
+		dmrResponse := DmrResponse{
+			Result: &size,
+		}
+
+		err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+		if err != nil {
+			return err
+		}
+		if dmrResponse.Outcome != "success" {
+			return fmt.Errorf("Cannot read max-pool-size of datasource %s: %s", ds, dmrResponse.FailureDescription)
+		}
+
+		if size.Value == 0 {
+			size.Value = defaultMaxPoolSize
+		}
+		if size.Value > requestCountData.poolSizes[ds] {
+			requestCountData.poolSizes[ds] = size.Value
+		}
+	}
+
+	return nil
+}
+
+// MemoryUsage is a JVM memory usage of the platform MBeans.
+type MemoryUsage struct {
+	Init      int64 `json:"init"`
+	Used      int64 `json:"used"`
+	Committed int64 `json:"committed"`
+	Max       int64 `json:"max"`
+}
+
+// checkHeap reads how much of its max heap the JVM uses.
+func (self *DmrContainer) checkHeap(ctx context.Context, requestCountData *RequestCountData) error {
+	dmrRequest := DmrAttributeRequest{
+		Operation: "read-attribute",
+		Name:      "heap-memory-usage",
+		Address:   []string{"core-service", "platform-mbean", "type", "memory"},
+		Pretty:    1,
+	}
+
+	usage := MemoryUsage{}
+	dmrResponse := DmrResponse{
+		Result: &usage,
+	}
+
+	err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+	if err != nil {
+		return err
+	}
+	if dmrResponse.Outcome != "success" {
+		return fmt.Errorf("Cannot read heap-memory-usage: %s", dmrResponse.FailureDescription)
+	}
+
+	max := usage.Max
+	if max <= 0 {
+		max = usage.Committed // no max set
+	}
+	if max > 0 {
+		requestCountData.heap[self.Pod.ID] = float64(usage.Used) / float64(max)
+	}
+	return nil
+}
+
+func (self *DmrContainer) getStats(ctx context.Context, request interface{}, result interface{}) error {
 	reqBody, err := json.Marshal(request)
 	if err != nil {
 		return err
@@ -181,7 +581,7 @@ func (self *DmrContainer) getStats(request interface{}, result interface{}) erro
 
 	url := fmt.Sprintf("http://%s:%d/management", self.Host, self.DmrPort)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return err
 	}
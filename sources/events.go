@@ -0,0 +1,43 @@
+package sources
+
+import (
+	"fmt"
+	"time"
+
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kube_util "github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+)
+
+// RecordEvent attaches an event to the named replication controller, so it shows up in `oc describe`.
+// Failing to record an event is logged, it never stops scaling.
+func (self *KubeClient) RecordEvent(rcName, reason, message string) {
+	glog.Infof("Event %s on %s: %s", reason, rcName, message)
+	if !self.canActuate() {
+		return
+	}
+
+	now := kube_util.Now()
+	event := &kube_api.Event{
+		ObjectMeta: kube_api.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", rcName, time.Now().UnixNano()),
+			Namespace: *argNamespace,
+		},
+		InvolvedObject: kube_api.ObjectReference{
+			Kind:      "ReplicationController",
+			Namespace: *argNamespace,
+			Name:      rcName,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         kube_api.EventSource{Component: "ascaler"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := self.client.Events(*argNamespace).Create(event)
+	if err != nil {
+		glog.Errorf("Error recording event %s on %s: %s", reason, rcName, err)
+	}
+}
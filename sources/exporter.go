@@ -0,0 +1,78 @@
+package sources
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics served on /metrics of the status API, labelled by target.
+var (
+	observedRateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "observed_rate",
+		Help:      "Request rate per second last observed.",
+	}, []string{"target"})
+	forecastRateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "forecast_rate",
+		Help:      "Request rate per second forecast one start-up time ahead.",
+	}, []string{"target"})
+	projectedRateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "projected_rate",
+		Help:      "Request rate per second extrapolated from the trend one start-up time ahead.",
+	}, []string{"target"})
+	startupSecondsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "startup_seconds",
+		Help:      "Learned time from pod creation to serving its first requests.",
+	}, []string{"target"})
+	forecastReplicasGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "forecast_replicas",
+		Help:      "Replicas needed for the forecast rate.",
+	}, []string{"target"})
+	forecastAbsErrorGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "forecast_mean_absolute_error",
+		Help:      "Moving average of the absolute one bucket ahead forecast error, in requests per second.",
+	}, []string{"target"})
+	forecastPctErrorGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "forecast_mean_absolute_percentage_error",
+		Help:      "Moving average of the one bucket ahead forecast error, relative to the observed rate.",
+	}, []string{"target"})
+	safeModeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "safe_mode",
+		Help:      "1 while the target holds its replicas because its metrics cannot be trusted, 0 otherwise.",
+	}, []string{"target"})
+	safeModeCoverageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "metrics_coverage",
+		Help:      "Fraction of the ready pods that had metrics on the last poll.",
+	}, []string{"target"})
+	flapsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "flaps",
+		Help:      "Scaling direction changes within the flap window.",
+	}, []string{"target"})
+	flapDampingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ascaler",
+		Name:      "flap_damping_level",
+		Help:      "How much scale downs are damped because of flapping, 0 when not flapping.",
+	}, []string{"target"})
+)
+
+func init() {
+	prometheus.MustRegister(observedRateGauge)
+	prometheus.MustRegister(forecastRateGauge)
+	prometheus.MustRegister(forecastReplicasGauge)
+	prometheus.MustRegister(projectedRateGauge)
+	prometheus.MustRegister(startupSecondsGauge)
+	prometheus.MustRegister(forecastAbsErrorGauge)
+	prometheus.MustRegister(forecastPctErrorGauge)
+	prometheus.MustRegister(safeModeGauge)
+	prometheus.MustRegister(safeModeCoverageGauge)
+	prometheus.MustRegister(flapsGauge)
+	prometheus.MustRegister(flapDampingGauge)
+}
@@ -0,0 +1,403 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/golang/glog"
+)
+
+var argPolicyExpression = flag.String("policy_expression", "", "Expression of the metrics giving the replicas of the expression policy, e.g. max(ceil(rate/800), ceil(heap_used_pct/0.7*replicas))")
+
+// expressionMetrics are the metrics an expression can use, see Observation.Metric.
+var expressionMetrics = map[string]bool{
+	"rate": true, "latency": true, "utilization": true, "pods": true, "replicas": true, "heap_used_pct": true,
+}
+
+// expressionFunctions are the functions an expression can call, with their number of arguments (-1 for any).
+var expressionFunctions = map[string]int{
+	"ceil": 1, "floor": 1, "round": 1, "abs": 1, "min": -1, "max": -1, "avg": 2,
+}
+
+// Metric returns a named metric of the observation.
+func (self Observation) Metric(name string) float64 {
+	switch name {
+	case "rate":
+		return self.Rate
+	case "latency":
+		return self.Latency
+	case "utilization":
+		return self.Utilization()
+	case "pods":
+		return float64(self.Pods)
+	case "replicas":
+		return float64(self.Replicas)
+	case "heap_used_pct":
+		return self.Heap
+	}
+	return 0
+}
+
+// Expression is a parsed arithmetic expression of metrics. Evaluating it cannot fail: it only
+// refers to known metrics and functions, and division by zero gives an infinity.
+type Expression interface {
+	Eval(history []Observation) float64 // history ends with the current observation
+	Metrics() []string
+}
+
+type numberExpression float64
+
+func (self numberExpression) Eval(history []Observation) float64 { return float64(self) }
+func (self numberExpression) Metrics() []string                  { return nil }
+
+type metricExpression string
+
+func (self metricExpression) Eval(history []Observation) float64 {
+	return history[len(history)-1].Metric(string(self))
+}
+func (self metricExpression) Metrics() []string { return []string{string(self)} }
+
+type negateExpression struct {
+	operand Expression
+}
+
+func (self *negateExpression) Eval(history []Observation) float64 { return -self.operand.Eval(history) }
+func (self *negateExpression) Metrics() []string                  { return self.operand.Metrics() }
+
+type binaryExpression struct {
+	operator    rune
+	left, right Expression
+}
+
+func (self *binaryExpression) Eval(history []Observation) float64 {
+	left, right := self.left.Eval(history), self.right.Eval(history)
+	switch self.operator {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	}
+	return left / right
+}
+
+func (self *binaryExpression) Metrics() []string {
+	return append(self.left.Metrics(), self.right.Metrics()...)
+}
+
+type callExpression struct {
+	function string
+	args     []Expression
+}
+
+func (self *callExpression) Eval(history []Observation) float64 {
+	values := make([]float64, len(self.args))
+	for i, arg := range self.args {
+		values[i] = arg.Eval(history)
+	}
+	switch self.function {
+	case "ceil":
+		return math.Ceil(values[0])
+	case "floor":
+		return math.Floor(values[0])
+	case "round":
+		return math.Floor(values[0] + 0.5)
+	case "abs":
+		return math.Abs(values[0])
+	case "min":
+		result := values[0]
+		for _, value := range values[1:] {
+			result = math.Min(result, value)
+		}
+		return result
+	}
+	result := values[0] // max
+	for _, value := range values[1:] {
+		result = math.Max(result, value)
+	}
+	return result
+}
+
+func (self *callExpression) Metrics() []string {
+	metrics := make([]string, 0)
+	for _, arg := range self.args {
+		metrics = append(metrics, arg.Metrics()...)
+	}
+	return metrics
+}
+
+// averageExpression is avg(metric, window): the mean of the metric over the observations of the window.
+type averageExpression struct {
+	metric string
+	window time.Duration
+}
+
+func (self *averageExpression) Eval(history []Observation) float64 {
+	now := history[len(history)-1].At
+	sum, n := 0.0, 0
+	for _, observation := range history {
+		if now.Sub(observation.At) <= self.window {
+			sum += observation.Metric(self.metric)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+func (self *averageExpression) Metrics() []string { return []string{self.metric} }
+
+type expressionParser struct {
+	tokens []string
+	next   int
+}
+
+// tokenize splits an expression into numbers (with an optional duration unit), names and operators.
+func tokenize(input string) ([]string, error) {
+	tokens := make([]string, 0)
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsDigit(r) || r == '.':
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			for i < len(runes) && unicode.IsLetter(runes[i]) {
+				i++ // duration unit
+			}
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+		case strings.ContainsRune("+-*/(),", r):
+			i++
+		default:
+			return nil, fmt.Errorf("Unexpected %q at %v", r, i)
+		}
+		tokens = append(tokens, string(runes[start:i]))
+	}
+	return tokens, nil
+}
+
+// parseExpression parses and validates an expression.
+func parseExpression(input string) (Expression, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	parser := &expressionParser{tokens: tokens}
+	expression, err := parser.sum()
+	if err != nil {
+		return nil, err
+	}
+	if parser.next < len(tokens) {
+		return nil, fmt.Errorf("Unexpected %q", tokens[parser.next])
+	}
+	return expression, nil
+}
+
+func (self *expressionParser) peek() string {
+	if self.next < len(self.tokens) {
+		return self.tokens[self.next]
+	}
+	return ""
+}
+
+func (self *expressionParser) expect(token string) error {
+	if self.peek() != token {
+		return fmt.Errorf("Expected %q instead of %q", token, self.peek())
+	}
+	self.next++
+	return nil
+}
+
+// sum := product (('+' | '-') product)*
+func (self *expressionParser) sum() (Expression, error) {
+	left, err := self.product()
+	for err == nil && (self.peek() == "+" || self.peek() == "-") {
+		operator := rune(self.tokens[self.next][0])
+		self.next++
+		var right Expression
+		right, err = self.product()
+		left = &binaryExpression{operator: operator, left: left, right: right}
+	}
+	return left, err
+}
+
+// product := unary (('*' | '/') unary)*
+func (self *expressionParser) product() (Expression, error) {
+	left, err := self.unary()
+	for err == nil && (self.peek() == "*" || self.peek() == "/") {
+		operator := rune(self.tokens[self.next][0])
+		self.next++
+		var right Expression
+		right, err = self.unary()
+		left = &binaryExpression{operator: operator, left: left, right: right}
+	}
+	return left, err
+}
+
+// unary := '-' unary | number | metric | function '(' arguments ')' | '(' sum ')'
+func (self *expressionParser) unary() (Expression, error) {
+	token := self.peek()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("Unexpected end of expression")
+	case token == "-":
+		self.next++
+		operand, err := self.unary()
+		return &negateExpression{operand: operand}, err
+	case token == "(":
+		self.next++
+		expression, err := self.sum()
+		if err != nil {
+			return nil, err
+		}
+		return expression, self.expect(")")
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		self.next++
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %q", token)
+		}
+		return numberExpression(value), nil
+	}
+
+	self.next++
+	if self.peek() != "(" {
+		if !expressionMetrics[token] {
+			return nil, fmt.Errorf("Unknown metric %q", token)
+		}
+		return metricExpression(token), nil
+	}
+	arity, found := expressionFunctions[token]
+	if !found {
+		return nil, fmt.Errorf("Unknown function %q", token)
+	}
+	if token == "avg" {
+		return self.average()
+	}
+
+	self.next++
+	args := make([]Expression, 0)
+	for self.peek() != ")" {
+		if len(args) > 0 {
+			if err := self.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := self.sum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	self.next++
+	if len(args) == 0 || (arity > 0 && len(args) != arity) {
+		return nil, fmt.Errorf("Wrong number of arguments of %s: %v", token, len(args))
+	}
+	return &callExpression{function: token, args: args}, nil
+}
+
+// average := 'avg' '(' metric ',' duration ')'
+func (self *expressionParser) average() (Expression, error) {
+	self.next++
+	metric := self.peek()
+	if !expressionMetrics[metric] {
+		return nil, fmt.Errorf("avg needs a metric instead of %q", metric)
+	}
+	self.next++
+	if err := self.expect(","); err != nil {
+		return nil, err
+	}
+	window, err := time.ParseDuration(self.peek())
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("avg needs a window like 5m instead of %q", self.peek())
+	}
+	self.next++
+	return &averageExpression{metric: metric, window: window}, self.expect(")")
+}
+
+func validateExpression() error {
+	if *argPolicyExpression == "" {
+		return fmt.Errorf("The expression policy needs a policy_expression")
+	}
+	_, err := parseExpression(*argPolicyExpression)
+	if err != nil {
+		return fmt.Errorf("Invalid policy_expression: %s", err)
+	}
+	return nil
+}
+
+// ExpressionPolicy asks for the replicas an expression of the metrics gives, rounded up.
+type ExpressionPolicy struct {
+	expression Expression
+	window     time.Duration // longest avg window
+	history    []Observation
+}
+
+func newExpressionPolicy() *ExpressionPolicy {
+	expression, _ := parseExpression(*argPolicyExpression) // validated at start up
+	policy := &ExpressionPolicy{expression: expression}
+	policy.window = longestWindow(expression)
+	return policy
+}
+
+func longestWindow(expression Expression) time.Duration {
+	switch expression := expression.(type) {
+	case *averageExpression:
+		return expression.window
+	case *negateExpression:
+		return longestWindow(expression.operand)
+	case *binaryExpression:
+		left, right := longestWindow(expression.left), longestWindow(expression.right)
+		if left > right {
+			return left
+		}
+		return right
+	case *callExpression:
+		longest := time.Duration(0)
+		for _, arg := range expression.args {
+			if window := longestWindow(arg); window > longest {
+				longest = window
+			}
+		}
+		return longest
+	}
+	return 0
+}
+
+// uses tells if the expression refers to the metric, so it is only collected when needed.
+func (self *ExpressionPolicy) uses(metric string) bool {
+	for _, name := range self.expression.Metrics() {
+		if name == metric {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *ExpressionPolicy) Replicas(observation Observation) int {
+	self.history = append(self.history, observation)
+	for len(self.history) > 1 && observation.At.Sub(self.history[0].At) > self.window {
+		self.history = self.history[1:]
+	}
+
+	value := self.expression.Eval(self.history)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		glog.Warningf("Policy expression gave %v, keeping %v replicas", value, observation.Replicas)
+		return clampReplicas(observation.Replicas)
+	}
+	glog.V(1).Infof("Policy expression gave %v", value)
+	return clampReplicas(int(math.Ceil(value)))
+}
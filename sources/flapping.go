@@ -0,0 +1,139 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argFlapReversals = flag.Int("flap_reversals", 4, "Scaling direction changes within flap_window after which a target is flapping (0 disables damping)")
+	argFlapWindow    = flag.Duration("flap_window", 30*time.Minute, "Window the direction changes are counted in, and how long damping lasts after the last one")
+	argFlapCooldown  = flag.Duration("flap_cooldown", 5*time.Minute, "Time between a replica change and a scale down of a flapping target, per damping level")
+)
+
+// maxFlapLevel bounds how far damping escalates while a target keeps flapping.
+const maxFlapLevel = 4
+
+func validateFlapping() error {
+	if *argFlapReversals < 0 {
+		return fmt.Errorf("Invalid flap_reversals %v", *argFlapReversals)
+	}
+	if *argFlapWindow <= 0 || *argFlapCooldown < 0 {
+		return fmt.Errorf("Invalid flap_window %v or flap_cooldown %v", *argFlapWindow, *argFlapCooldown)
+	}
+	return nil
+}
+
+// FlapState is what the flap damper carries across restarts.
+type FlapState struct {
+	Direction   int         `json:"direction,omitempty"`   // of the last replica change, 1 up or -1 down
+	LastChange  time.Time   `json:"lastChange,omitempty"`  // of the replicas
+	Reversals   []time.Time `json:"reversals,omitempty"`   // direction changes within the window
+	Level       int         `json:"level,omitempty"`       // damping, 0 when not flapping
+	Detected    time.Time   `json:"detected,omitempty"`    // when the level last went up
+	DampedUntil time.Time   `json:"dampedUntil,omitempty"` // a window after the last reversal while damped
+}
+
+// FlapDamper notices targets scaling up and down over and over, and damps their scale downs: each damping
+// level widens the hysteresis by a pod and lengthens the cooldown by flap_cooldown. Scale ups are never
+// held, flapping is damped enough by holding one direction and the load must not be refused.
+type FlapDamper struct {
+	name  string
+	state FlapState
+}
+
+func newFlapDamper(name string, state *FlapState) *FlapDamper {
+	damper := &FlapDamper{name: name}
+	if state != nil {
+		damper.state = *state
+	}
+	return damper
+}
+
+// Flaps is how often the target changed direction within the window.
+func (self *FlapDamper) Flaps() int {
+	return len(self.state.Reversals)
+}
+
+// Damp holds a scale down of a flapping target unless it removes more pods than the damping level, after
+// the cooldown of the level.
+func (self *FlapDamper) Damp(client *KubeClient, targets []*ScaleTarget, current, replicas int, now time.Time) int {
+	if self.state.Level > 0 && now.After(self.state.DampedUntil) {
+		self.state.Level = 0
+		self.record(client, targets, "FlappingStopped",
+			fmt.Sprintf("No scaling direction change for %v, no longer damping scale downs", *argFlapWindow))
+	}
+	if self.state.Level == 0 || replicas >= current {
+		return replicas
+	}
+
+	if current-replicas <= self.state.Level {
+		glog.Infof("Holding %v replicas of flapping %s, a scale down to %v is within the damping of %v pods",
+			current, self.name, replicas, self.state.Level)
+		return current
+	}
+	cooldown := time.Duration(self.state.Level) * *argFlapCooldown
+	if since := now.Sub(self.state.LastChange); since < cooldown {
+		glog.Infof("Holding %v replicas of flapping %s, the last change was %v ago, cooling down for %v",
+			current, self.name, since, cooldown)
+		return current
+	}
+	return replicas
+}
+
+// Observe records a replica change of the target, moved pods up or down, and escalates the damping
+// when the direction changed more than flap_reversals times within the window.
+func (self *FlapDamper) Observe(client *KubeClient, targets []*ScaleTarget, moved int, now time.Time) {
+	kept := self.state.Reversals[:0]
+	for _, reversal := range self.state.Reversals {
+		if now.Sub(reversal) <= *argFlapWindow {
+			kept = append(kept, reversal)
+		}
+	}
+	self.state.Reversals = kept
+	if moved == 0 {
+		return
+	}
+
+	direction := 1
+	if moved < 0 {
+		direction = -1
+	}
+	reversed := self.state.Direction != 0 && direction != self.state.Direction
+	self.state.Direction = direction
+	self.state.LastChange = now
+	if !reversed || *argFlapReversals == 0 {
+		return
+	}
+	self.state.Reversals = append(self.state.Reversals, now)
+	if self.state.Level > 0 {
+		self.state.DampedUntil = now.Add(*argFlapWindow)
+	}
+
+	// only reversals since the last escalation count toward the next one
+	recent := 0
+	for _, reversal := range self.state.Reversals {
+		if reversal.After(self.state.Detected) || self.state.Level == 0 {
+			recent++
+		}
+	}
+	if recent <= *argFlapReversals || self.state.Level >= maxFlapLevel {
+		return
+	}
+	self.state.Level++
+	self.state.Detected = now
+	self.state.DampedUntil = now.Add(*argFlapWindow)
+	glog.Warningf("%s is flapping: %v direction changes within %v", self.name, len(self.state.Reversals), *argFlapWindow)
+	self.record(client, targets, "Flapping", fmt.Sprintf("Changed scaling direction %v times within %v, scale downs "+
+		"now have to remove at least %v pods and wait %v after a change; the rate may sit on a boundary or eap_pod_rate be wrong",
+		len(self.state.Reversals), *argFlapWindow, self.state.Level+1, time.Duration(self.state.Level)**argFlapCooldown))
+}
+
+func (self *FlapDamper) record(client *KubeClient, targets []*ScaleTarget, reason, message string) {
+	for _, target := range targets {
+		client.RecordEvent(target.name, reason, message)
+	}
+}
@@ -0,0 +1,208 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argForecast       = flag.Bool("forecast", false, "Provision replicas for the rate forecast one start-up time ahead, if higher than the current one")
+	argForecastBucket = flag.Duration("forecast_bucket", 5*time.Minute, "Resolution of the rate history the forecast learns, must divide a day and be at least 5m")
+	argForecastWarmup = flag.Duration("forecast_warmup", 7*24*time.Hour, "History needed before the forecast is used")
+)
+
+// Smoothing factors of the level, trend, daily and weekly seasons.
+const (
+	forecastAlpha = 0.3
+	forecastBeta  = 0.01
+	forecastGamma = 0.3
+	forecastDelta = 0.5
+
+	// forecastErrorWeight is the weight of the latest error in its moving average.
+	forecastErrorWeight = 0.05
+)
+
+// minForecastBucket keeps the model, a week of buckets checkpointed in the state annotation, well within
+// the 256KB annotations of an object may take: at 5 minutes it is about 30KB.
+const minForecastBucket = 5 * time.Minute
+
+func validateForecast() error {
+	if *argForecastBucket < minForecastBucket || (24*time.Hour)%*argForecastBucket != 0 {
+		return fmt.Errorf("Invalid forecast_bucket %v, it must divide a day and be at least %v", *argForecastBucket, minForecastBucket)
+	}
+	return nil
+}
+
+// HoltWinters is an additive Holt-Winters model with a daily and a weekly season, learning
+// the mean rate of every bucket. Seasons are float32 to keep the checkpoint small.
+type HoltWinters struct {
+	Bucket   time.Duration `json:"bucket"`
+	Last     int64         `json:"last"`     // index of the last bucket learned
+	Observed int           `json:"observed"` // buckets learned
+	Level    float64       `json:"level"`
+	Trend    float64       `json:"trend"`
+	Daily    []float32     `json:"daily"`
+	Weekly   []float32     `json:"weekly"`
+
+	// the bucket being filled
+	Current      int64   `json:"current"`
+	CurrentSum   float64 `json:"currentSum"`
+	CurrentCount int     `json:"currentCount"`
+
+	// moving averages of the one bucket ahead error
+	AbsError float64 `json:"absError"`
+	PctError float64 `json:"pctError"`
+}
+
+func newHoltWinters(bucket time.Duration) *HoltWinters {
+	return &HoltWinters{
+		Bucket: bucket,
+		Daily:  make([]float32, int(24*time.Hour/bucket)),
+		Weekly: make([]float32, int(7*24*time.Hour/bucket)),
+	}
+}
+
+func (self *HoltWinters) season(index int64) float64 {
+	return float64(self.Daily[index%int64(len(self.Daily))] + self.Weekly[index%int64(len(self.Weekly))])
+}
+
+// forecast predicts the mean rate of a bucket after the last one learned.
+func (self *HoltWinters) forecast(index int64) float64 {
+	value := self.Level + float64(index-self.Last)*self.Trend + self.season(index)
+	return math.Max(value, 0)
+}
+
+// learn updates the model with the mean rate of a bucket.
+func (self *HoltWinters) learn(index int64, value float64) {
+	if self.Observed == 0 || index-self.Last > int64(len(self.Weekly)) {
+		// nothing learned, or too long ago to carry on from
+		*self = *newHoltWinters(self.Bucket)
+		self.Level = value
+		self.Last = index
+		self.Observed = 1
+		return
+	}
+	if index <= self.Last {
+		// the clock went back, or a restored model is ahead of it
+		if self.Last-index > int64(len(self.Daily)) {
+			glog.Warningf("Forecast model is %v ahead of the clock, starting over", time.Duration(self.Last-index)*self.Bucket)
+			self.Observed = 0
+			self.learn(index, value)
+		}
+		return
+	}
+
+	predicted := self.forecast(index)
+	diff := math.Abs(value - predicted)
+	self.AbsError = forecastErrorWeight*diff + (1-forecastErrorWeight)*self.AbsError
+	if value > 0 {
+		self.PctError = forecastErrorWeight*100*diff/value + (1-forecastErrorWeight)*self.PctError
+	}
+
+	daily := index % int64(len(self.Daily))
+	weekly := index % int64(len(self.Weekly))
+	previous := self.Level
+	self.Level = forecastAlpha*(value-self.season(index)) + (1-forecastAlpha)*(self.Level+float64(index-self.Last)*self.Trend)
+	self.Trend = forecastBeta*(self.Level-previous)/float64(index-self.Last) + (1-forecastBeta)*self.Trend
+	self.Daily[daily] = float32(forecastGamma*(value-self.Level-float64(self.Weekly[weekly])) + (1-forecastGamma)*float64(self.Daily[daily]))
+	self.Weekly[weekly] = float32(forecastDelta*(value-self.Level-float64(self.Daily[daily])) + (1-forecastDelta)*float64(self.Weekly[weekly]))
+	self.Last = index
+	self.Observed++
+}
+
+// Observe adds a rate sample, learning the previous bucket's mean once a new bucket starts.
+func (self *HoltWinters) Observe(at time.Time, rate float64) {
+	index := at.UnixNano() / int64(self.Bucket)
+	if index != self.Current && self.CurrentCount > 0 {
+		self.learn(self.Current, self.CurrentSum/float64(self.CurrentCount))
+		self.CurrentSum, self.CurrentCount = 0, 0
+	}
+	self.Current = index
+	self.CurrentSum += rate
+	self.CurrentCount++
+}
+
+// Trained tells if enough history was learned to trust the forecast.
+func (self *HoltWinters) Trained() bool {
+	return self.Observed > 0 && time.Duration(self.Observed)*self.Bucket >= *argForecastWarmup
+}
+
+// Predict forecasts the rate at the given time.
+func (self *HoltWinters) Predict(at time.Time) float64 {
+	index := at.UnixNano() / int64(self.Bucket)
+	if index <= self.Last {
+		index = self.Last + 1
+	}
+	return self.forecast(index)
+}
+
+// ForecastStatus is what the status API reports about a target's forecast.
+type ForecastStatus struct {
+	Rate     float64 `json:"rate"`
+	Replicas int     `json:"replicas"`
+	Trained  bool    `json:"trained"`
+	AbsError float64 `json:"meanAbsoluteError"`
+	PctError float64 `json:"meanAbsolutePercentageError"`
+}
+
+// Forecaster provisions a target for the rate forecast one start-up time ahead.
+type Forecaster struct {
+	name   string
+	model  *HoltWinters
+	status *ForecastStatus
+}
+
+// newForecaster carries on with a checkpointed model, unless it was learned with another bucket.
+func newForecaster(name string, model *HoltWinters) *Forecaster {
+	if model == nil || model.Bucket != *argForecastBucket ||
+		len(model.Daily) != int(24*time.Hour/model.Bucket) || len(model.Weekly) != int(7*24*time.Hour/model.Bucket) {
+		model = newHoltWinters(*argForecastBucket)
+	}
+	return &Forecaster{name: name, model: model}
+}
+
+// Replicas learns the current rate and returns the replicas needed for the forecast one,
+// 0 while the model is not trained yet.
+func (self *Forecaster) Replicas(rate float64, lead time.Duration) int {
+	now := time.Now()
+	self.model.Observe(now, rate)
+	forecast := self.model.Predict(now.Add(lead))
+	replicas := clampReplicas(int(forecast/float64(*eapPodRate)) + 1)
+
+	self.status = &ForecastStatus{
+		Rate:     forecast,
+		Replicas: replicas,
+		Trained:  self.model.Trained(),
+		AbsError: self.model.AbsError,
+		PctError: self.model.PctError,
+	}
+	observedRateGauge.WithLabelValues(self.name).Set(rate)
+	forecastRateGauge.WithLabelValues(self.name).Set(forecast)
+	forecastReplicasGauge.WithLabelValues(self.name).Set(float64(replicas))
+	forecastAbsErrorGauge.WithLabelValues(self.name).Set(self.model.AbsError)
+	forecastPctErrorGauge.WithLabelValues(self.name).Set(self.model.PctError)
+
+	if !self.status.Trained {
+		glog.V(1).Infof("Forecast of %s not trained yet, %v buckets learned", self.name, self.model.Observed)
+		return 0
+	}
+	glog.Infof("Forecast rate of %s in %v: %.1f (%v replicas), mean error %.1f%%",
+		self.name, lead, forecast, replicas, self.model.PctError)
+	return replicas
+}
+
+// Apply returns max(forecast, reactive) replicas, or the reactive ones if forecasting is off.
+func (self *Forecaster) Apply(rate float64, replicas int, lead time.Duration) int {
+	if self == nil {
+		return replicas
+	}
+	if predicted := self.Replicas(rate, lead); predicted > replicas {
+		glog.Infof("Provisioning %v replicas of %s ahead of the forecast load", predicted, self.name)
+		return predicted
+	}
+	return replicas
+}
@@ -0,0 +1,152 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kube_labels "github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
+	"github.com/golang/glog"
+)
+
+var (
+	argMaxScrapeFailures       = flag.Int("max_scrape_failures", 3, "Consecutive DMR scrape failures after which a pod is quarantined")
+	argRestartQuarantine       = flag.Duration("restart_quarantine", 5*time.Minute, "How long a pod stays quarantined after one of its containers restarted")
+	argDeleteUnresponsiveAfter = flag.Duration("delete_unresponsive_after", 0, "Delete pods without a successful DMR scrape for this long (0 disables)")
+)
+
+// PodHealth tracks how reliably a pod can be scraped. Quarantined pods are still scraped,
+// but left out of the rate aggregation until they recover.
+type PodHealth struct {
+	Name           string    `json:"name"`
+	Failures       int       `json:"consecutiveFailures"`
+	Restarts       int       `json:"restarts"`
+	RestartDelta   int       `json:"restartDelta"`
+	LastRestart    time.Time `json:"lastRestart,omitempty"`
+	LastSample     time.Time `json:"lastSample,omitempty"`
+	FirstSeen      time.Time `json:"firstSeen"`
+	Quarantined    bool      `json:"quarantined"`
+	QuarantineNote string    `json:"quarantineReason,omitempty"`
+
+	labels kube_labels.Set // to find the controller owning the pod
+}
+
+func containerRestarts(pod *kube_api.Pod) int {
+	restarts := 0
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
+	}
+	return restarts
+}
+
+// checkRestarts updates the restart counters of a running pod.
+func (self *KubeSource) checkRestarts(pod *kube_api.Pod, seen map[types.UID]*PodHealth) *PodHealth {
+	health, found := self.health[pod.UID]
+	if !found {
+		health = &PodHealth{Name: pod.Name, FirstSeen: time.Now()}
+	}
+	seen[pod.UID] = health
+	health.labels = kube_labels.Set(pod.Labels)
+
+	restarts := containerRestarts(pod)
+	health.RestartDelta = 0
+	if found && restarts > health.Restarts {
+		health.RestartDelta = restarts - health.Restarts
+		health.LastRestart = time.Now()
+	}
+	health.Restarts = restarts
+
+	health.update()
+	return health
+}
+
+func (self *PodHealth) recordScrape(err error) {
+	if err != nil {
+		self.Failures++
+	} else {
+		self.Failures = 0
+		self.LastSample = time.Now()
+	}
+	self.update()
+}
+
+func (self *PodHealth) update() {
+	quarantined := false
+	if self.Failures >= *argMaxScrapeFailures {
+		quarantined = true
+		self.QuarantineNote = fmt.Sprintf("%d consecutive scrape failures", self.Failures)
+	} else if !self.LastRestart.IsZero() && time.Since(self.LastRestart) < *argRestartQuarantine {
+		quarantined = true
+		self.QuarantineNote = fmt.Sprintf("restarted %v ago", time.Since(self.LastRestart))
+	}
+
+	if quarantined != self.Quarantined {
+		if quarantined {
+			glog.Warningf("Quarantining pod %s: %s", self.Name, self.QuarantineNote)
+		} else {
+			glog.Infof("Pod %s recovered from quarantine", self.Name)
+		}
+	}
+	if !quarantined {
+		self.QuarantineNote = ""
+	}
+	self.Quarantined = quarantined
+}
+
+// unresponsiveFor tells how long the pod has not answered a scrape.
+func (self *PodHealth) unresponsiveFor() time.Duration {
+	if self.LastSample.IsZero() {
+		return time.Since(self.FirstSeen)
+	}
+	return time.Since(self.LastSample)
+}
+
+// deleteUnresponsive removes the pod that did not answer a scrape for the longest, so the RC replaces
+// it. At most one pod goes per poll, and none when most pods fail at once or the target is in safe
+// mode: then DMR or the network is more likely broken than the pods, and deleting them would not help.
+func (self *KubeSource) deleteUnresponsive(entry *RequestCountData) {
+	if *argDeleteUnresponsiveAfter <= 0 {
+		return
+	}
+	if entry != nil && entry.safeMode.reason != "" {
+		return
+	}
+
+	failing := 0
+	var victim types.UID
+	for uid, health := range self.health {
+		if health.Failures == 0 {
+			continue
+		}
+		failing++
+		if health.unresponsiveFor() < *argDeleteUnresponsiveAfter {
+			continue
+		}
+		if victim == "" || health.unresponsiveFor() > self.health[victim].unresponsiveFor() {
+			victim = uid
+		}
+	}
+	if victim == "" {
+		return
+	}
+	if failing*2 > len(self.health) {
+		glog.Warningf("Not deleting unresponsive pods, %v of %v pods fail to answer", failing, len(self.health))
+		return
+	}
+
+	health := self.health[victim]
+	err := self.client.DeletePod(health.Name)
+	if err != nil {
+		glog.Errorf("Error deleting unresponsive pod %s: %s", health.Name, err)
+		return
+	}
+	owner := *eapReplicationController
+	if entry != nil {
+		owner = entry.ownerOf(health.labels)
+	}
+	self.client.RecordEvent(owner, "UnresponsivePodDeleted",
+		fmt.Sprintf("Deleted pod %s, no DMR response for %v", health.Name, health.unresponsiveFor()))
+	delete(self.health, victim)
+}
@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
@@ -45,8 +46,11 @@ func max64(x, y int64) int64 {
 	return int64(math.Max(float64(x), float64(y)))
 }
 
-func (self *InfluxdbSource) CheckData() error {
+func (self *InfluxdbSource) CheckData(ctx context.Context) error {
 	for _, metric := range self.metrics {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		err := metric.Execute(self)
 		if err != nil {
 			glog.Errorf("Error checking data for metric %s -> %s", metric, err)
@@ -55,10 +59,26 @@ func (self *InfluxdbSource) CheckData() error {
 	return nil
 }
 
+// Close saves the state of every metric and releases the leadership.
+func (self *InfluxdbSource) Close() error {
+	for _, metric := range self.metrics {
+		if eapMetric, ok := metric.(*SimpleEapMetric); ok {
+			eapMetric.target.checkpoint(self.kubeClient, true)
+		}
+	}
+	self.kubeClient.Release()
+	return nil
+}
+
 // TODO make this more generic
-func getMetrics() []Metric {
+func getMetrics(kubeClient *KubeClient) []Metric {
 	ms := make([]Metric, 0)
-	return append(ms, &SimpleEapMetric{})
+	target := newScaleTarget(kubeClient, *eapReplicationController, *eapSelector)
+	metric := &SimpleEapMetric{target: target, safeMode: newSafeMode(target.name)}
+	if *argForecast {
+		metric.forecaster = newForecaster(target.name, target.state.Forecast)
+	}
+	return append(ms, metric)
 }
 
 func NewInfluxdbSource(duration *time.Duration) (Source, error) {
@@ -85,13 +105,16 @@ func NewInfluxdbSource(duration *time.Duration) (Source, error) {
 		return nil, err
 	}
 
+	kubeClient := newKubeClient(transport)
+	statusRegistry.SetClient(kubeClient)
+
 	// Create the database if it does not already exist. Ignore errors.
 	return &InfluxdbSource{
 		Poll_time:  duration,
 		client:     client,
 		dbName:     *argDbName,
 		lastWrite:  time.Now(),
-		kubeClient: newKubeClient(transport),
-		metrics:    getMetrics(),
+		kubeClient: kubeClient,
+		metrics:    getMetrics(kubeClient),
 	}, nil
 }
@@ -1,12 +1,14 @@
 package sources
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
 	kube_fields "github.com/GoogleCloudPlatform/kubernetes/pkg/fields"
 	kube_labels "github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
 	"github.com/golang/glog"
 	"time"
 )
@@ -17,9 +19,11 @@ type KubeSource struct {
 	environment *Environment
 	selectors   []string
 	data        map[string]QueryEntry
+	readySince  map[types.UID]time.Time // when we first saw a pod ready
+	health      map[types.UID]*PodHealth
 }
 
-func (self *KubeSource) parsePod(pod *kube_api.Pod) *Pod {
+func (self *KubeSource) parsePod(pod *kube_api.Pod, selector string) *Pod {
 	localPod := Pod{
 		Namespace:  pod.Namespace,
 		Name:       pod.Name,
@@ -27,6 +31,7 @@ func (self *KubeSource) parsePod(pod *kube_api.Pod) *Pod {
 		PodIP:      pod.Status.PodIP,
 		Hostname:   pod.Status.HostIP,
 		Status:     string(pod.Status.Phase),
+		Created:    pod.CreationTimestamp.Time,
 		Labels:     make(map[string]string, 0),
 		Containers: make([]Container, 0),
 	}
@@ -44,6 +49,7 @@ func (self *KubeSource) parsePod(pod *kube_api.Pod) *Pod {
 				localContainer.Name = container.Name
 				localContainer.Host = env.GetHost(pod, port)
 				localContainer.DmrPort = env.GetPort(pod, port)
+				localContainer.Selector = selector
 				localPod.Containers = append(localPod.Containers, localContainer)
 				break
 			}
@@ -59,36 +65,60 @@ func (self *KubeSource) parsePod(pod *kube_api.Pod) *Pod {
 	return &localPod
 }
 
-func (self *KubeSource) getPods(selector string) ([]Pod, error) {
+// getPods returns the ready and warmed up pods, and counts the ones still on their way.
+func (self *KubeSource) getPods(selector string) ([]Pod, PodCounts, error) {
+	counts := PodCounts{}
 	sc, err := kube_labels.Parse(selector)
 	if err != nil {
-		return nil, err
+		return nil, counts, err
 	}
 
 	pods, err := self.client.Pods(*argNamespace).List(sc, kube_fields.Everything())
 	if err != nil {
-		return nil, err
+		return nil, counts, err
 	}
 	glog.V(1).Infof("got pods from api server %+v", pods)
 	out := make([]Pod, 0)
+	readySince := make(map[types.UID]time.Time)
+	health := make(map[types.UID]*PodHealth)
 	for _, pod := range pods.Items {
-		if pod.Status.Phase == kube_api.PodRunning {
-			pod := self.parsePod(&pod)
-			out = append(out, *pod)
+		if pod.Status.Phase == kube_api.PodPending {
+			counts.addPending(&pod)
+			continue
+		}
+		if pod.Status.Phase != kube_api.PodRunning {
+			continue
+		}
+		self.checkRestarts(&pod, health)
+		if !isPodReady(&pod) || !self.isWarm(&pod, readySince) {
+			glog.V(1).Infof("pod %s is not ready or warmed up yet", pod.Name)
+			counts.Booting++
+			continue
 		}
+		pod := self.parsePod(&pod, selector)
+		pod.Ready = readySince[pod.ID]
+		out = append(out, *pod)
 	}
+	self.readySince = readySince
+	self.health = health
 
-	return out, nil
+	return out, counts, nil
 }
 
-func (self *KubeSource) CheckData() error {
+func (self *KubeSource) CheckData(ctx context.Context) error {
 	for _, selector := range self.selectors {
 
-		pods, err := self.getPods(selector)
+		pods, counts, err := self.getPods(selector)
 		if err != nil {
 			return err
 		}
 
+		entry, _ := self.GetData(selector).(*RequestCountData)
+		if entry != nil {
+			entry.counts = counts
+			entry.ready = len(pods)
+		}
+
 		if len(pods) == 0 {
 			glog.Warningf("No pods found for selector %s", selector)
 			continue
@@ -98,17 +128,32 @@ func (self *KubeSource) CheckData() error {
 			for _, container := range pod.Containers {
 				glog.Infof("Container --> %s", container.GetName())
 
-				err := container.CheckStats(self)
+				err := container.CheckStats(ctx, self)
+				if ctx.Err() != nil {
+					// shutting down, a cancelled scrape says nothing about the pod
+					return ctx.Err()
+				}
 
 				if err != nil {
 					glog.Errorf("Error checking container [%s] stats: %s", container.GetName(), err)
 				}
+
+				health := self.health[pod.ID]
+				health.recordScrape(err)
+				if health.Quarantined && entry != nil {
+					entry.quarantined[pod.ID] = true
+					if _, scraped := entry.scraped[pod.ID]; scraped {
+						entry.ready-- // quarantined after a restart although it answers, its metrics are not missing
+					}
+				}
 			}
 		}
 
-		entry := self.GetData(selector)
+		self.deleteUnresponsive(entry)
+
 		if entry != nil {
-			err = entry.Calculate(self.client)
+			err = entry.Calculate(ctx, self.client)
+			self.publishStatus(entry)
 			if err != nil {
 				return err
 			}
@@ -117,6 +162,87 @@ func (self *KubeSource) CheckData() error {
 	return nil
 }
 
+func (self *KubeSource) publishStatus(entry *RequestCountData) {
+	pods := make([]PodHealth, 0, len(self.health))
+	for _, health := range self.health {
+		pods = append(pods, *health)
+	}
+
+	service := ""
+	if entry.service != nil {
+		service = entry.service.name
+	}
+
+	for _, target := range entry.targets {
+		status := targetStatus(target, pods)
+		status.Service = service
+		status.CappedBy = entry.cappedBy
+		status.Booting = entry.counts.Booting
+		status.Pending = entry.counts.Pending
+		status.Constrained = entry.constrained
+		if entry.forecaster != nil {
+			status.Forecast = entry.forecaster.status
+		}
+		status.StartupTime = entry.startup.Lead().String()
+		status.ProjectedRate = entry.projected
+		if webhook, ok := entry.policy.(*WebhookPolicy); ok {
+			status.PolicyReason = webhook.reason
+		}
+		status.ShadowPolicies = entry.shadows.Recommended()
+		status.Flaps = entry.flaps.Flaps()
+		status.FlapDamping = entry.flaps.state.Level
+		status.MetricsCoverage = entry.safeMode.coverage
+		status.LastCompleteMetrics = entry.safeMode.lastComplete
+		status.SafeMode = entry.safeMode.reason
+		statusRegistry.Publish(status)
+	}
+
+	for _, dependent := range entry.dependents {
+		status := targetStatus(dependent.target, nil)
+		status.DependsOn = *eapReplicationController
+		if service != "" {
+			status.DependsOn = service
+		}
+		statusRegistry.Publish(status)
+	}
+}
+
+// targetStatus reports what every target has in common.
+func targetStatus(target *ScaleTarget, pods []PodHealth) TargetStatus {
+	status := TargetStatus{
+		ReplicationController: target.name,
+		Replicas:              target.currentReplicas,
+		Override:              target.override.current,
+		Schedule:              target.schedule.current,
+		Conflict:              target.conflicts.conflict,
+		Rollout:               target.rollout.inProgress,
+		Recommended:           target.recommended,
+		Pods:                  pods,
+	}
+	if target.state.Manual != nil {
+		manual := *target.state.Manual
+		status.Manual = &manual
+	}
+	return status
+}
+
+// Close saves the state of every target and releases the leadership.
+func (self *KubeSource) Close() error {
+	for _, entry := range self.data {
+		if requestCountData, ok := entry.(*RequestCountData); ok {
+			for _, target := range requestCountData.targets {
+				requestCountData.saveLearned(target)
+				target.checkpoint(self.client, true)
+			}
+			for _, dependent := range requestCountData.dependents {
+				dependent.target.checkpoint(self.client, true)
+			}
+		}
+	}
+	self.client.Release()
+	return nil
+}
+
 func (self *KubeSource) GetData(selector string) QueryEntry {
 	return self.data[selector]
 }
@@ -138,13 +264,44 @@ func NewKubeSource(d *time.Duration) (*KubeSource, error) {
 		return nil, err
 	}
 
+	budget, err := newConnectionBudget()
+	if err != nil {
+		return nil, err
+	}
+
 	kubeClient := newKubeClient(transport)
 
+	statusRegistry.SetClient(kubeClient)
+
+	selector := *eapSelector
+	var service *ServiceTarget
+	var targets []*ScaleTarget
+	if *argEapService != "" {
+		service, err = newServiceTarget(kubeClient, *argEapService)
+		if err != nil {
+			return nil, err
+		}
+		selector = service.Selector()
+		targets, err = service.Refresh(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		targets = []*ScaleTarget{newScaleTarget(kubeClient, *eapReplicationController, *eapSelector)}
+	}
+	dependents, err := newDependents(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	requestCountData := newRequestCountData(kubeClient, targets, service, dependents, budget)
+
 	return &KubeSource{
 		Poll_time:   d,
 		client:      kubeClient,
 		environment: newEnvironment(),
-		selectors:   []string{*eapSelector},
-		data:        make(map[string]QueryEntry),
+		selectors:   []string{selector},
+		data:        map[string]QueryEntry{selector: requestCountData},
+		readySince:  make(map[types.UID]time.Time),
+		health:      make(map[types.UID]*PodHealth),
 	}, nil
 }
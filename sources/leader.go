@@ -0,0 +1,200 @@
+package sources
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"sync"
+	"time"
+
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kube_errors "github.com/GoogleCloudPlatform/kubernetes/pkg/api/errors"
+	"github.com/golang/glog"
+)
+
+var (
+	argLeaderElect = flag.Bool("leader_elect", false, "Only actuate while holding the leader lease, for running several ascaler replicas")
+	argLeaderLock  = flag.String("leader_lock", "ascaler", "Name of the Endpoints object holding the leader lease")
+	argLeaderLease = flag.Duration("leader_lease", 15*time.Second, "How long a leader lease lasts without renewal, i.e. the handover time")
+	argLeaderId    = flag.String("leader_id", "", "Identity of this replica in the leader election, defaults to the hostname")
+)
+
+// leaderAnnotation holds the lease record on the lock object.
+const leaderAnnotation = "ascaler/leader"
+
+// LeaderRecord is the lease stored as JSON in the lock object's annotation.
+type LeaderRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+// LeaderElector keeps trying to acquire or renew a lease stored in an annotation of an Endpoints object.
+// Updates carry the object's resource version, so only one replica can win a given round.
+type LeaderElector struct {
+	client   *KubeClient
+	identity string
+
+	lock     sync.RWMutex
+	leader   bool
+	renewed  time.Time // when we last wrote our lease
+	observed LeaderRecord
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newLeaderElector(client *KubeClient) *LeaderElector {
+	identity := *argLeaderId
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return &LeaderElector{client: client, identity: identity, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// IsLeader tells if we hold the lease. A leader that could not renew it for a lease duration, e.g. cut off
+// from the API server, may already have been replaced and stops actuating.
+func (self *LeaderElector) IsLeader() bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.leader && time.Since(self.renewed) < *argLeaderLease
+}
+
+func (self *LeaderElector) setLeader(leader bool, record LeaderRecord) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if leader != self.leader {
+		if leader {
+			glog.Infof("%s became the leader", self.identity)
+		} else {
+			glog.Infof("%s lost the leadership to %s", self.identity, record.HolderIdentity)
+		}
+	}
+	self.leader = leader
+	self.observed = record
+	if leader {
+		self.renewed = record.RenewTime
+	}
+}
+
+// Run renews or tries to acquire the lease a few times per lease duration, until released.
+func (self *LeaderElector) Run() {
+	defer close(self.done)
+	ticker := time.NewTicker(*argLeaderLease / 3)
+	defer ticker.Stop()
+	for {
+		err := self.tryAcquireOrRenew()
+		if err != nil {
+			glog.Errorf("Error in leader election: %s", err)
+		}
+		select {
+		case <-self.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Release stops the election and, if we hold the lease, expires it so a standby takes over right away.
+func (self *LeaderElector) Release() {
+	close(self.stop)
+	<-self.done
+	if !self.IsLeader() {
+		return
+	}
+
+	endpoints, err := self.client.client.Endpoints(*argNamespace).Get(*argLeaderLock)
+	if err != nil {
+		glog.Errorf("Error releasing the leadership: %s", err)
+		return
+	}
+
+	record := LeaderRecord{}
+	err = json.Unmarshal([]byte(endpoints.Annotations[leaderAnnotation]), &record)
+	if err != nil || record.HolderIdentity != self.identity {
+		return
+	}
+
+	record.HolderIdentity = ""
+	self.lock.Lock()
+	self.leader = false
+	self.lock.Unlock()
+	err = self.update(endpoints, record, false)
+	if err != nil {
+		glog.Errorf("Error releasing the leadership: %s", err)
+		return
+	}
+	glog.Infof("%s released the leadership", self.identity)
+}
+
+func (self *LeaderElector) tryAcquireOrRenew() error {
+	now := time.Now()
+	record := LeaderRecord{
+		HolderIdentity:       self.identity,
+		LeaseDurationSeconds: int(argLeaderLease.Seconds()),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	endpoints, err := self.client.client.Endpoints(*argNamespace).Get(*argLeaderLock)
+	if err != nil {
+		if !kube_errors.IsNotFound(err) {
+			return err
+		}
+		endpoints = &kube_api.Endpoints{
+			ObjectMeta: kube_api.ObjectMeta{Name: *argLeaderLock, Namespace: *argNamespace},
+		}
+		return self.write(endpoints, record, true)
+	}
+
+	current := LeaderRecord{}
+	if value, found := endpoints.Annotations[leaderAnnotation]; found {
+		if err := json.Unmarshal([]byte(value), &current); err != nil {
+			return err
+		}
+	}
+
+	lease := time.Duration(current.LeaseDurationSeconds) * time.Second
+	if current.HolderIdentity != self.identity && current.HolderIdentity != "" && now.Before(current.RenewTime.Add(lease)) {
+		self.setLeader(false, current)
+		return nil
+	}
+
+	if current.HolderIdentity == self.identity {
+		record.AcquireTime = current.AcquireTime
+	}
+	return self.write(endpoints, record, false)
+}
+
+func (self *LeaderElector) update(endpoints *kube_api.Endpoints, record LeaderRecord, create bool) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if endpoints.Annotations == nil {
+		endpoints.Annotations = make(map[string]string)
+	}
+	endpoints.Annotations[leaderAnnotation] = string(value)
+
+	if create {
+		_, err = self.client.client.Endpoints(*argNamespace).Create(endpoints)
+	} else {
+		_, err = self.client.client.Endpoints(*argNamespace).Update(endpoints)
+	}
+	return err
+}
+
+func (self *LeaderElector) write(endpoints *kube_api.Endpoints, record LeaderRecord, create bool) error {
+	err := self.update(endpoints, record, create)
+	if err != nil {
+		// somebody else won this round, we find out who on the next one
+		self.setLeader(false, self.observed)
+		if kube_errors.IsConflict(err) || kube_errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+
+	self.setLeader(true, record)
+	return nil
+}
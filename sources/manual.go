@@ -0,0 +1,64 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argManualScalePolicy = flag.String("manual_scale_policy", "adopt", "What to do when somebody else changed the replicas: adopt (as a floor), backoff or fight")
+	argManualScaleHold   = flag.Duration("manual_scale_hold", 30*time.Minute, "How long an adopted floor or a back off lasts")
+)
+
+// ManualScale is a replica count set by a human or another controller, respected until a given time.
+type ManualScale struct {
+	Replicas int       `json:"replicas"`
+	Policy   string    `json:"policy"`
+	Until    time.Time `json:"until"`
+}
+
+func validateManualScalePolicy() error {
+	switch *argManualScalePolicy {
+	case "adopt", "backoff", "fight":
+		return nil
+	}
+	return fmt.Errorf("Invalid manual_scale_policy: %s", *argManualScalePolicy)
+}
+
+// checkManualScale compares the actual replicas with the ones we last wrote, and applies the manual
+// scale policy to the replicas we want. It returns the replicas to use and whether we may actuate.
+func (self *ControllerState) checkManualScale(client *KubeClient, name string, written, actual, replicas int) (int, bool) {
+	if actual != written && written > 0 {
+		if *argManualScalePolicy == "fight" {
+			client.RecordEvent(name, "ManualScaleOverridden",
+				fmt.Sprintf("Replicas changed from %v to %v outside ascaler, overriding them", written, actual))
+			self.Manual = nil
+		} else {
+			self.Manual = &ManualScale{Replicas: actual, Policy: *argManualScalePolicy, Until: time.Now().Add(*argManualScaleHold)}
+			client.RecordEvent(name, "ManualScaleDetected",
+				fmt.Sprintf("Replicas changed from %v to %v outside ascaler, %s until %s", written, actual, self.Manual.Policy, self.Manual.Until.Format(time.RFC3339)))
+		}
+	}
+
+	manual := self.Manual
+	if manual == nil {
+		return replicas, true
+	}
+	if !time.Now().Before(manual.Until) {
+		client.RecordEvent(name, "ManualScaleExpired", fmt.Sprintf("Manual scale to %v no longer %s", manual.Replicas, manual.Policy))
+		self.Manual = nil
+		return replicas, true
+	}
+	if manual.Policy == "backoff" {
+		glog.Infof("Backing off scaling %s until %v", name, manual.Until)
+		return actual, false
+	}
+	if replicas < manual.Replicas {
+		glog.Infof("Keeping %s at the manually set floor of %v replicas", name, manual.Replicas)
+		return manual.Replicas, true
+	}
+	return replicas, true
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	influxdb "github.com/influxdb/influxdb/client"
+	"sort"
 	"strings"
+	"time"
 )
 
 var (
@@ -43,8 +45,71 @@ func toMap(series []*influxdb.Series) (map[string]int64, error) {
 	return sm, nil
 }
 
+// history reads the mean rate of every forecast bucket over the forecast warm-up, oldest first.
+func history(source *InfluxdbSource, table string) ([]time.Time, []float64, error) {
+	query := fmt.Sprintf("SELECT DERIVATIVE(request_count) FROM %s WHERE time > now() - %ds GROUP BY time(%ds)",
+		table, int(argForecastWarmup.Seconds()), int(argForecastBucket.Seconds()))
+	series, err := source.client.Query(query, influxdb.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// every pod is a series, average them like the current rate
+	sums := make(map[int64]float64)
+	counts := make(map[int64]int)
+	for _, s := range series {
+		for _, point := range s.Points {
+			if len(point) < 2 {
+				continue
+			}
+			at, ok1 := point[0].(float64)
+			value, ok2 := point[len(point)-1].(float64)
+			if !ok1 || !ok2 {
+				continue
+			}
+			sums[int64(at)] += value
+			counts[int64(at)]++
+		}
+	}
+
+	seconds := make([]int64, 0, len(sums))
+	for at := range sums {
+		seconds = append(seconds, at)
+	}
+	sort.Slice(seconds, func(i, j int) bool { return seconds[i] < seconds[j] })
+
+	times := make([]time.Time, len(seconds))
+	rates := make([]float64, len(seconds))
+	for i, at := range seconds {
+		times[i] = time.Unix(at, 0)
+		rates[i] = sums[at] / float64(counts[at])
+	}
+	return times, rates, nil
+}
+
 type SimpleEapMetric struct {
-	currentReplicas int
+	target     *ScaleTarget // the replication controller we scale
+	forecaster *Forecaster  // optional rate forecast
+	trained    bool         // whether the forecast learned the InfluxDB history
+	safeMode   *SafeMode    // holds the replicas when InfluxDB misses series
+}
+
+// train teaches a fresh forecast the rate history kept in InfluxDB.
+func (self *SimpleEapMetric) train(source *InfluxdbSource) {
+	self.trained = true
+	if self.forecaster.model.Observed > 0 {
+		return // carry on with the checkpointed model
+	}
+
+	times, rates, err := history(source, *argEapDbTable)
+	if err != nil {
+		glog.Errorf("Error reading rate history: %s", err)
+		return
+	}
+	for i := range times {
+		self.forecaster.model.Observe(times[i], rates[i])
+	}
+	glog.Infof("Forecast of %s learned %v buckets of history from InfluxDB", self.forecaster.name, self.forecaster.model.Observed)
 }
 
 func (self *SimpleEapMetric) Execute(source *InfluxdbSource) error {
@@ -52,13 +117,26 @@ func (self *SimpleEapMetric) Execute(source *InfluxdbSource) error {
 
 	// current data
 
-	newS, err := query(source, simple_eap_columns, *argEapDbTable, 0)
+	newS, queryErr := query(source, simple_eap_columns, *argEapDbTable, 0)
+
+	n := int64(len(newS))
+
+	// every ready pod should have a series, fewer look like less load
+	expected, err := source.kubeClient.countReadyPods(*eapSelector)
 	if err != nil {
 		return err
 	}
+	if self.safeMode.Check(source.kubeClient, []*ScaleTarget{self.target}, int(n), expected, time.Now()) {
+		err = self.scale(source, self.safeMode.Replicas(self.target.currentReplicas))
+		if err != nil {
+			return err
+		}
+		return queryErr
+	}
 
-	n := int64(len(newS))
-
+	if queryErr != nil {
+		return queryErr
+	}
 	if n == 0 {
 		return nil
 	}
@@ -88,21 +166,40 @@ func (self *SimpleEapMetric) Execute(source *InfluxdbSource) error {
 	}
 
 	replicas := int((sum/n)/int64(*eapPodRate)) + 1
+	if self.forecaster != nil {
+		if !self.trained {
+			self.train(source)
+		}
+		replicas = self.forecaster.Apply(float64(sum/n), replicas, *argStartupTime)
+	}
 	// limit replicas
 	if replicas > *maxEapPods {
 		replicas = *maxEapPods
 	}
 
-	if replicas > 0 && self.currentReplicas != replicas {
-		glog.Infof("Applying replicas: %v", replicas)
+	return self.scale(source, replicas)
+}
 
-		err := source.kubeClient.SetReplicas(*eapReplicationController, replicas)
+// scale applies the replicas to the target.
+func (self *SimpleEapMetric) scale(source *InfluxdbSource, replicas int) error {
+	decision, err := self.target.Decide(source.kubeClient, replicas)
+	if err != nil {
+		return err
+	}
+
+	if decision.Changed && decision.Replicas > 0 {
+		glog.Infof("Applying replicas: %v", decision.Replicas)
+
+		decision.Changed, err = source.kubeClient.SetReplicas(decision.Controller, decision.Replicas)
 		if err != nil {
 			return err
 		}
+	}
 
-		self.currentReplicas = replicas
+	if self.forecaster != nil {
+		self.target.state.Forecast = self.forecaster.model
 	}
+	self.target.Applied(source.kubeClient, decision)
 
 	return nil
 }
@@ -0,0 +1,224 @@
+package sources
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Annotations on the target replication controller that take it out of automatic scaling.
+const (
+	pausedAnnotation      = "ascaler/paused"
+	pinReplicasAnnotation = "ascaler/pin-replicas"
+	pinUntilAnnotation    = "ascaler/pin-until"
+)
+
+// Override is a manual control of a target: either paused, or pinned to some replicas until a given time.
+type Override struct {
+	Paused      bool      `json:"paused,omitempty"`
+	PinReplicas int       `json:"pinReplicas,omitempty"`
+	PinUntil    time.Time `json:"pinUntil,omitempty"`
+}
+
+func (self Override) Pinned() bool {
+	return self.PinReplicas > 0
+}
+
+func (self Override) Active() bool {
+	return self.Paused || self.Pinned()
+}
+
+func (self Override) String() string {
+	if self.Paused {
+		return "paused"
+	}
+	if self.Pinned() {
+		return fmt.Sprintf("pinned to %v replicas until %s", self.PinReplicas, self.PinUntil.Format(time.RFC3339))
+	}
+	return "none"
+}
+
+func parseOverride(annotations map[string]string) (Override, error) {
+	override := Override{}
+	if value, found := annotations[pausedAnnotation]; found {
+		paused, err := strconv.ParseBool(value)
+		if err != nil {
+			return override, fmt.Errorf("Invalid %s annotation: %s", pausedAnnotation, value)
+		}
+		override.Paused = paused
+	}
+
+	value, found := annotations[pinReplicasAnnotation]
+	if !found {
+		return override, nil
+	}
+	replicas, err := strconv.Atoi(value)
+	if err != nil || replicas <= 0 {
+		return override, fmt.Errorf("Invalid %s annotation: %s", pinReplicasAnnotation, value)
+	}
+	until, err := time.Parse(time.RFC3339, annotations[pinUntilAnnotation])
+	if err != nil {
+		return override, fmt.Errorf("Invalid %s annotation: %s", pinUntilAnnotation, annotations[pinUntilAnnotation])
+	}
+	override.PinReplicas = replicas
+	override.PinUntil = until
+	return override, nil
+}
+
+func (self *KubeClient) GetOverride(name string) (Override, error) {
+	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
+	if err != nil {
+		return Override{}, err
+	}
+	return parseOverride(rc.Annotations)
+}
+
+// SetOverride stores the override in the replication controller's annotations. It is not gated
+// by the leadership: the leader picks the annotations up on its next poll.
+func (self *KubeClient) SetOverride(name string, override Override) error {
+	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
+	if err != nil {
+		return err
+	}
+
+	if rc.Annotations == nil {
+		rc.Annotations = make(map[string]string)
+	}
+	delete(rc.Annotations, pausedAnnotation)
+	delete(rc.Annotations, pinReplicasAnnotation)
+	delete(rc.Annotations, pinUntilAnnotation)
+	if override.Paused {
+		rc.Annotations[pausedAnnotation] = "true"
+	}
+	if override.Pinned() {
+		rc.Annotations[pinReplicasAnnotation] = strconv.Itoa(override.PinReplicas)
+		rc.Annotations[pinUntilAnnotation] = override.PinUntil.Format(time.RFC3339)
+	}
+
+	_, err = self.client.ReplicationControllers(*argNamespace).Update(rc)
+	return err
+}
+
+// OverrideTracker applies a target's override to the replicas we decided on, and reports its changes.
+type OverrideTracker struct {
+	current Override
+}
+
+// Apply returns the replicas to use and whether actuation is allowed at all. The annotations are the
+// controller's, as read for the decision.
+func (self *OverrideTracker) Apply(client *KubeClient, name string, annotations map[string]string, replicas int) (int, bool) {
+	override, err := parseOverride(annotations)
+	if err != nil {
+		glog.Errorf("Error reading override of %s: %s", name, err)
+		override = self.current // keep what we knew
+	}
+
+	if override.Pinned() && !time.Now().Before(override.PinUntil) && client.canActuate() {
+		client.RecordEvent(name, "OverrideExpired", fmt.Sprintf("Override expired: %s", override))
+		override.PinReplicas = 0
+		override.PinUntil = time.Time{}
+		err = client.SetOverride(name, override)
+		if err != nil {
+			glog.Errorf("Error clearing expired override of %s: %s", name, err)
+		}
+	}
+
+	if override != self.current {
+		if override.Active() {
+			client.RecordEvent(name, "OverrideApplied", fmt.Sprintf("Automatic scaling overridden: %s", override))
+		} else {
+			client.RecordEvent(name, "OverrideRemoved", "Automatic scaling resumed")
+		}
+		self.current = override
+	}
+
+	if override.Paused {
+		glog.Infof("Scaling of %s is paused, not applying %v replicas", name, replicas)
+		return replicas, false
+	}
+	if override.Pinned() {
+		glog.Infof("Replicas of %s are %s", name, override)
+		return override.PinReplicas, true
+	}
+	return replicas, true
+}
+
+// serveTargets handles POST /targets/<rc>/pause, /targets/<rc>/resume and
+// /targets/<rc>/pin?replicas=N&until=<RFC3339> (or &for=<duration>) on the controllers we scale.
+func (self *StatusRegistry) serveTargets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if self.token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(self.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if self.client == nil {
+		http.Error(w, "No Kubernetes client", http.StatusServiceUnavailable)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/targets/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := parts[0], parts[1]
+	if !self.isManaged(name) {
+		http.Error(w, fmt.Sprintf("%s is not scaled by this ascaler", name), http.StatusNotFound)
+		return
+	}
+
+	override, err := self.client.GetOverride(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "pause":
+		override.Paused = true
+	case "resume":
+		override = Override{}
+	case "pin":
+		override.PinReplicas, err = strconv.Atoi(r.FormValue("replicas"))
+		if err != nil || override.PinReplicas <= 0 {
+			http.Error(w, "Invalid replicas", http.StatusBadRequest)
+			return
+		}
+		if value := r.FormValue("for"); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				http.Error(w, "Invalid for duration", http.StatusBadRequest)
+				return
+			}
+			override.PinUntil = time.Now().Add(duration)
+		} else {
+			override.PinUntil, err = time.Parse(time.RFC3339, r.FormValue("until"))
+			if err != nil {
+				http.Error(w, "Invalid until time", http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	err = self.client.SetOverride(name, override)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Override of %s set to %s via the status API", name, override)
+	fmt.Fprintf(w, "%s\n", override)
+}
@@ -0,0 +1,60 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+var argPendingTimeout = flag.Duration("pending_timeout", 2*time.Minute, "How long a pod may stay pending before the target is reported as capacity constrained")
+
+// PodCounts tracks the pods of a selector that do not serve requests yet.
+type PodCounts struct {
+	Booting int      // running, but not ready or warmed up
+	Pending int      // not running yet, e.g. waiting for a node or its image
+	Stuck   []string // pending pods past the pending timeout, or failing to pull their image
+}
+
+// addPending counts a pod which is not running yet, and remembers it if it looks stuck.
+func (self *PodCounts) addPending(pod *kube_api.Pod) {
+	self.Pending++
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && isImagePullFailure(status.State.Waiting.Reason) {
+			self.Stuck = append(self.Stuck, fmt.Sprintf("%s (%s)", pod.Name, status.State.Waiting.Reason))
+			return
+		}
+	}
+
+	age := time.Since(pod.CreationTimestamp.Time)
+	if age >= *argPendingTimeout {
+		reason := "pending"
+		if pod.Spec.Host == "" {
+			reason = "unschedulable"
+		}
+		self.Stuck = append(self.Stuck, fmt.Sprintf("%s (%s for %v)", pod.Name, reason, age))
+	}
+}
+
+func isImagePullFailure(reason string) bool {
+	reason = strings.ToLower(reason)
+	return strings.Contains(reason, "pull") &&
+		(strings.Contains(reason, "err") || strings.Contains(reason, "fail") || strings.Contains(reason, "backoff"))
+}
+
+// checkConstrained raises an event whenever the target starts or stops waiting for cluster capacity.
+func (self *RequestCountData) checkConstrained(client *KubeClient) {
+	constrained := len(self.counts.Stuck) > 0
+	for _, target := range self.targets {
+		if constrained && !self.constrained {
+			client.RecordEvent(target.name, "CapacityConstrained",
+				fmt.Sprintf("Scale up held, pods cannot start: %s", strings.Join(self.counts.Stuck, ", ")))
+		} else if !constrained && self.constrained {
+			client.RecordEvent(target.name, "CapacityAvailable", "Pending pods are starting, scale up resumed")
+		}
+	}
+	self.constrained = constrained
+}
@@ -0,0 +1,102 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argPIDSignal   = flag.String("pid_signal", "utilization", "Signal the pid policy steers: utilization (rate / pods x eap_pod_rate) or latency (mean latency / latency_slo)")
+	argPIDSetpoint = flag.Float64("pid_setpoint", 0.7, "Value of the signal the pid policy steers toward")
+	argPIDKp       = flag.Float64("pid_kp", 2, "Proportional gain, in replicas per unit of signal error")
+	argPIDKi       = flag.Float64("pid_ki", 0.02, "Integral gain, in replicas per unit of signal error and second")
+	argPIDKd       = flag.Float64("pid_kd", 0, "Derivative gain, in replicas per unit of signal error per second")
+	argLatencySLO  = flag.Duration("latency_slo", 500*time.Millisecond, "Mean request latency the latency signal is relative to")
+)
+
+func validatePID() error {
+	if *argPIDSignal != "utilization" && *argPIDSignal != "latency" {
+		return fmt.Errorf("Invalid pid_signal: %s", *argPIDSignal)
+	}
+	if *argPIDSetpoint <= 0 {
+		return fmt.Errorf("Invalid pid_setpoint %v, it must be positive", *argPIDSetpoint)
+	}
+	if *argPIDKp < 0 || *argPIDKi < 0 || *argPIDKd < 0 {
+		return fmt.Errorf("PID gains must not be negative")
+	}
+	if *argPIDSignal == "latency" && *argLatencySLO <= 0 {
+		return fmt.Errorf("Invalid latency_slo %v", *argLatencySLO)
+	}
+	return nil
+}
+
+// PIDState is what the pid policy carries from one poll to the next, and across restarts.
+type PIDState struct {
+	Integral float64   `json:"integral"` // error x seconds
+	Error    float64   `json:"error"`    // last error
+	Last     time.Time `json:"last"`     // last observation
+}
+
+// PIDPolicy steers a per pod signal toward a setpoint. Its output is in replicas:
+// kp x error + ki x integral + kd x derivative, where error = signal - setpoint.
+type PIDPolicy struct {
+	state PIDState
+}
+
+func newPIDPolicy(state *PIDState) *PIDPolicy {
+	policy := &PIDPolicy{}
+	if state != nil {
+		policy.state = *state
+	}
+	return policy
+}
+
+func (self *PIDPolicy) signal(observation Observation) float64 {
+	if *argPIDSignal == "latency" {
+		return observation.Latency / (float64(*argLatencySLO) / float64(time.Millisecond))
+	}
+	return observation.Utilization()
+}
+
+func (self *PIDPolicy) Replicas(observation Observation) int {
+	err := self.signal(observation) - *argPIDSetpoint
+
+	if self.state.Last.IsZero() && *argPIDKi > 0 {
+		// bumpless start: let the integral hold the current replicas
+		self.state.Integral = (float64(observation.Replicas) - *argPIDKp*err) / *argPIDKi
+		self.state.Error = err
+		self.state.Last = observation.At
+	}
+
+	dt := observation.At.Sub(self.state.Last).Seconds()
+	derivative := 0.0
+	if dt > 0 {
+		derivative = (err - self.state.Error) / dt
+	}
+
+	// anti-windup: the integral alone never asks for more than the output range
+	integral := self.state.Integral + err*dt
+	if *argPIDKi > 0 {
+		integral = math.Max(float64(*minEapPods) / *argPIDKi, math.Min(integral, float64(*maxEapPods) / *argPIDKi))
+	}
+
+	output := *argPIDKp*err + *argPIDKi*integral + *argPIDKd*derivative
+	replicas := int(math.Floor(output + 0.5))
+	clamped := clampReplicas(replicas)
+
+	// anti-windup: no integrating further into a saturated output
+	saturated := (replicas > clamped && err > 0) || (replicas < clamped && err < 0)
+	if !saturated {
+		self.state.Integral = integral
+	}
+	self.state.Error = err
+	self.state.Last = observation.At
+
+	glog.V(1).Infof("PID: signal error %.3f, integral %.1f, derivative %.4f -> %.2f replicas (%v)",
+		err, self.state.Integral, derivative, output, clamped)
+	return clamped
+}
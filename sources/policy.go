@@ -0,0 +1,134 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	argPolicy  = flag.String("policy", "step", "How replicas are computed from the observed load: step (rate / eap_pod_rate + 1), utilization, rules, expression, pid or webhook")
+	minEapPods = flag.Int("min_eap_pods", 1, "Min EAP pod instances a policy may ask for")
+)
+
+// Observation is what a policy sees of a target's load on every poll.
+type Observation struct {
+	At       time.Time
+	Rate     float64          // requests per second over all scraped pods
+	Latency  float64          // mean request processing time in ms, 0 if nothing was processed
+	Heap     float64          // mean used / max heap of the pods, only collected when a policy needs it
+	Pods     int              // pods the rate was measured on
+	Replicas int              // current replicas
+	PerPod   []PodObservation // what each pod served, for the webhook policy
+
+	Annotations map[string]string // of the policy owner as read on the last poll, none when simulating
+}
+
+// Utilization is the rate relative to what the pods can take.
+func (self Observation) Utilization() float64 {
+	pods := self.Pods
+	if pods == 0 {
+		pods = self.Replicas
+	}
+	if pods == 0 {
+		return 0
+	}
+	return self.Rate / float64(pods**eapPodRate)
+}
+
+// Policy computes the replicas a target needs for the observed load.
+type Policy interface {
+	Replicas(observation Observation) int
+}
+
+// StepPolicy asks for one pod per eap_pod_rate requests per second, plus one.
+type StepPolicy struct{}
+
+func (self *StepPolicy) Replicas(observation Observation) int {
+	return int(observation.Rate)/(*eapPodRate) + 1
+}
+
+func validatePolicy() error {
+	if *minEapPods < 0 || *minEapPods > *maxEapPods {
+		return fmt.Errorf("Invalid min_eap_pods %v, it must be between 0 and max_eap_pods", *minEapPods)
+	}
+	// InfluxDB only has the request counts, the other policies need what DMR is asked for
+	if *sourceType == "influxdb" && *argPolicy != "step" {
+		return fmt.Errorf("Invalid policy %s, the influxdb source only supports step", *argPolicy)
+	}
+	return validatePolicyKind(*argPolicy)
+}
+
+func validatePolicyKind(kind string) error {
+	switch kind {
+	case "step":
+		return nil
+	case "utilization":
+		return validateUtilization()
+	case "rules":
+		return validateStepRules()
+	case "expression":
+		return validateExpression()
+	case "pid":
+		return validatePID()
+	case "webhook":
+		return validateWebhook()
+	}
+	return fmt.Errorf("Invalid policy: %s", kind)
+}
+
+// PolicyOwner is the object a target's policy is configured on: the service when scaling by service,
+// otherwise the replication controller.
+type PolicyOwner struct {
+	client *KubeClient // nil when simulating
+	name   string
+}
+
+// newPolicy creates the configured policy, carrying on with the checkpointed controller state.
+func newPolicy(owner PolicyOwner, restored *ControllerState) Policy {
+	return createPolicy(*argPolicy, owner, restored)
+}
+
+func createPolicy(kind string, owner PolicyOwner, restored *ControllerState) Policy {
+	switch kind {
+	case "utilization":
+		return newUtilizationPolicy(restored.Utilization)
+	case "rules":
+		return newStepRulesPolicy(owner, restored.Rules)
+	case "expression":
+		return newExpressionPolicy()
+	case "pid":
+		return newPIDPolicy(restored.PID)
+	case "webhook":
+		return newWebhookPolicy(owner, restored)
+	}
+	return &StepPolicy{}
+}
+
+// savePolicy stores the controller state of the policy.
+func savePolicy(policy Policy, state *ControllerState) {
+	switch policy := policy.(type) {
+	case *PIDPolicy:
+		pid := policy.state
+		state.PID = &pid
+	case *StepRulesPolicy:
+		rules := policy.state
+		state.Rules = &rules
+	case *UtilizationPolicy:
+		state.Utilization = append([]Recommendation(nil), policy.recommendations...)
+	case *WebhookPolicy:
+		state.WebhookChanged = policy.changed
+		savePolicy(policy.fallback, state)
+	}
+}
+
+// clampReplicas keeps replicas within min_eap_pods and max_eap_pods.
+func clampReplicas(replicas int) int {
+	if replicas < *minEapPods {
+		return *minEapPods
+	}
+	if replicas > *maxEapPods {
+		return *maxEapPods
+	}
+	return replicas
+}
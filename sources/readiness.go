@@ -0,0 +1,58 @@
+package sources
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
+)
+
+var argPodWarmup = flag.Duration("pod_warmup", 30*time.Second, "How long a pod must be ready before its metrics are used")
+
+func isPodReady(pod *kube_api.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == kube_api.PodReady {
+			return condition.Status == kube_api.ConditionTrue
+		}
+	}
+	return false
+}
+
+// isWarm tells if the pod has been ready for at least the warm-up period. The API does not
+// expose when a pod became ready, so we remember when we first saw it ready.
+func (self *KubeSource) isWarm(pod *kube_api.Pod, seen map[types.UID]time.Time) bool {
+	since, found := self.readySince[pod.UID]
+	if !found {
+		since = time.Now()
+	}
+	seen[pod.UID] = since
+	return time.Since(since) >= *argPodWarmup
+}
+
+// checkServerState tells if the EAP server serves requests. A server that only needs a reload or a
+// restart to apply configuration changes still serves, only a starting or stopping one does not.
+func (self *DmrContainer) checkServerState(ctx context.Context) (bool, error) {
+	dmrRequest := DmrAttributeRequest{
+		Operation: "read-attribute",
+		Name:      "server-state",
+		Pretty:    1,
+	}
+
+	state := ""
+	dmrResponse := DmrResponse{
+		Result: &state,
+	}
+
+	err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+	if err != nil {
+		return false, err
+	}
+	if dmrResponse.Outcome != "success" {
+		return false, fmt.Errorf("Cannot read server-state: %s", dmrResponse.FailureDescription)
+	}
+
+	return state != "starting" && state != "stopping", nil
+}
@@ -0,0 +1,133 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	kube_labels "github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/golang/glog"
+)
+
+var argRolloutMode = flag.String("rollout_mode", "freeze", "What to do while a rollout replaces the target's pods: freeze, or follow (apply the replicas to the newest controller)")
+
+// deploymentPhaseAnnotation is set by OpenShift deployments on the controllers they create.
+const deploymentPhaseAnnotation = "openshift.io/deployment.phase"
+
+// Rollout describes the replication controllers whose pods the target's selector matches.
+type Rollout struct {
+	InProgress bool
+	Reason     string
+	Newest     string         // newest controller with replicas
+	Replicas   map[string]int // controllers with replicas
+}
+
+func validateRolloutMode() error {
+	switch *argRolloutMode {
+	case "freeze", "follow":
+		return nil
+	}
+	return fmt.Errorf("Invalid rollout_mode: %s", *argRolloutMode)
+}
+
+// FindRollout looks at all controllers creating pods the selector matches. Either an OpenShift deployment
+// that is not complete yet, or several of them having replicas, means a rollout is going on.
+func (self *KubeClient) FindRollout(selector string) (*Rollout, error) {
+	sc, err := kube_labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := self.client.ReplicationControllers(*argNamespace).List(kube_labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	rollout := &Rollout{Replicas: make(map[string]int)}
+	var newest time.Time
+	for _, rc := range list.Items {
+		if rc.Spec.Template == nil || !sc.Matches(kube_labels.Set(rc.Spec.Template.Labels)) {
+			continue
+		}
+		switch phase := rc.Annotations[deploymentPhaseAnnotation]; phase {
+		case "New", "Pending", "Running":
+			rollout.InProgress = true
+			rollout.Reason = fmt.Sprintf("deployment %s is %s", rc.Name, phase)
+		}
+		if rc.Spec.Replicas == 0 && rc.Status.Replicas == 0 {
+			continue
+		}
+		rollout.Replicas[rc.Name] = rc.Spec.Replicas
+		if rc.CreationTimestamp.After(newest) {
+			newest = rc.CreationTimestamp.Time
+			rollout.Newest = rc.Name
+		}
+	}
+
+	if len(rollout.Replicas) > 1 && !rollout.InProgress {
+		names := make([]string, 0, len(rollout.Replicas))
+		for name := range rollout.Replicas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rollout.InProgress = true
+		rollout.Reason = fmt.Sprintf("controllers %s share the pods", strings.Join(names, ", "))
+	}
+	return rollout, nil
+}
+
+// RolloutTracker follows the rollouts of a target, and moves the target to the new controller once done.
+type RolloutTracker struct {
+	inProgress bool
+}
+
+// Check returns the current rollout of the target, nil if we cannot tell.
+func (self *RolloutTracker) Check(client *KubeClient, target *ScaleTarget) *Rollout {
+	rollout, err := client.FindRollout(target.selector)
+	if err != nil {
+		glog.Errorf("Error looking for rollouts of %s: %s", target.name, err)
+		return nil
+	}
+
+	if rollout.InProgress != self.inProgress {
+		if rollout.InProgress {
+			client.RecordEvent(target.name, "RolloutStarted", fmt.Sprintf("Rollout in progress (%s), ascaler will %s", rollout.Reason, *argRolloutMode))
+		} else {
+			client.RecordEvent(target.name, "RolloutFinished", "Rollout complete, ascaler resumes scaling")
+		}
+		self.inProgress = rollout.InProgress
+	}
+
+	// the rollout replaced our controller, carry on with the new one
+	if !rollout.InProgress && len(rollout.Replicas) == 1 && rollout.Newest != target.name {
+		client.RecordEvent(target.name, "TargetReplaced", fmt.Sprintf("Scaling %s from now on, it replaced %s", rollout.Newest, target.name))
+		self.carryOverride(client, target.name, rollout.Newest)
+		statusRegistry.Replace(target.name, rollout.Newest)
+		target.name = rollout.Newest
+		target.currentReplicas = rollout.Replicas[rollout.Newest]
+		target.state.Manual = nil
+		target.checkpoint(client, true)
+	}
+	return rollout
+}
+
+// carryOverride keeps a pause or pin of the replaced controller on the one replacing it.
+func (self *RolloutTracker) carryOverride(client *KubeClient, old, name string) {
+	if !client.canActuate() {
+		return
+	}
+	override, err := client.GetOverride(old)
+	if err != nil {
+		glog.Errorf("Error reading override of %s: %s", old, err)
+		return
+	}
+	if !override.Active() {
+		return
+	}
+	err = client.SetOverride(name, override)
+	if err != nil {
+		glog.Errorf("Error carrying override of %s over to %s: %s", old, name, err)
+	}
+}
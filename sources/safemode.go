@@ -0,0 +1,113 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	kube_api "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kube_fields "github.com/GoogleCloudPlatform/kubernetes/pkg/fields"
+	kube_labels "github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/golang/glog"
+)
+
+var (
+	argStaleMetricsAfter  = flag.Duration("stale_metrics_after", 5*time.Minute, "Enter safe mode when no poll had the metrics of every pod for this long")
+	argMinMetricsCoverage = flag.Float64("min_metrics_coverage", 0.5, "Enter safe mode when less than this fraction of the ready pods has metrics")
+	argFailsafeReplicas   = flag.Int("failsafe_replicas", 0, "Replicas to scale up to in safe mode, 0 holds the current replicas")
+)
+
+func validateSafeMode() error {
+	if *argStaleMetricsAfter <= 0 {
+		return fmt.Errorf("Invalid stale_metrics_after %v", *argStaleMetricsAfter)
+	}
+	if *argMinMetricsCoverage < 0 || *argMinMetricsCoverage > 1 {
+		return fmt.Errorf("Invalid min_metrics_coverage %v, it must be between 0 and 1", *argMinMetricsCoverage)
+	}
+	if *argFailsafeReplicas < 0 || *argFailsafeReplicas > *maxEapPods {
+		return fmt.Errorf("Invalid failsafe_replicas %v, it must be between 0 and max_eap_pods", *argFailsafeReplicas)
+	}
+	return nil
+}
+
+// SafeMode keeps a target from scaling on metrics it cannot trust: when too few pods have metrics, or
+// none of the recent polls had them all, the target holds its replicas, or goes up to failsafe_replicas.
+type SafeMode struct {
+	name         string
+	coverage     float64   // fraction of the ready pods with metrics on the last poll
+	lastComplete time.Time // last poll with the metrics of every ready pod
+	reason       string    // why the target is in safe mode, empty if it is not
+}
+
+func newSafeMode(name string) *SafeMode {
+	// a fresh start has not missed any metrics yet
+	return &SafeMode{name: name, coverage: 1, lastComplete: time.Now()}
+}
+
+// Check records how many of the expected pods had metrics and tells if the target is in safe mode.
+func (self *SafeMode) Check(client *KubeClient, targets []*ScaleTarget, measured, expected int, now time.Time) bool {
+	self.coverage = 1
+	if expected > 0 {
+		self.coverage = float64(measured) / float64(expected)
+	}
+	if measured >= expected {
+		self.lastComplete = now
+	}
+	safeModeCoverageGauge.WithLabelValues(self.name).Set(self.coverage)
+
+	reason := ""
+	if self.coverage < *argMinMetricsCoverage {
+		reason = fmt.Sprintf("only %v of %v ready pods have metrics", measured, expected)
+	} else if since := now.Sub(self.lastComplete); since > *argStaleMetricsAfter {
+		reason = fmt.Sprintf("no complete metrics for %v", since)
+	}
+
+	if reason != "" && self.reason == "" {
+		glog.Warningf("%s enters safe mode: %s", self.name, reason)
+		for _, target := range targets {
+			client.RecordEvent(target.name, "SafeModeEntered", fmt.Sprintf("Not scaling down, %s", reason))
+		}
+	} else if reason == "" && self.reason != "" {
+		glog.Infof("%s leaves safe mode, metrics are back", self.name)
+		for _, target := range targets {
+			client.RecordEvent(target.name, "SafeModeExited", "Metrics are back, scaling resumes")
+		}
+	}
+	self.reason = reason
+
+	safe := 0.0
+	if reason != "" {
+		safe = 1
+	}
+	safeModeGauge.WithLabelValues(self.name).Set(safe)
+	return reason != ""
+}
+
+// Replicas is what a target in safe mode scales to: never down, and up to the fail-safe replicas.
+func (self *SafeMode) Replicas(current int) int {
+	if *argFailsafeReplicas > current {
+		glog.Warningf("%s in safe mode, going to %v fail-safe replicas", self.name, *argFailsafeReplicas)
+		return *argFailsafeReplicas
+	}
+	glog.Warningf("%s in safe mode, holding %v replicas", self.name, current)
+	return current
+}
+
+// countReadyPods counts the ready pods of a selector, the ones metrics are expected of.
+func (self *KubeClient) countReadyPods(selector string) (int, error) {
+	sc, err := kube_labels.Parse(selector)
+	if err != nil {
+		return 0, err
+	}
+	pods, err := self.Pods(*argNamespace).List(sc, kube_fields.Everything())
+	if err != nil {
+		return 0, err
+	}
+	ready := 0
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == kube_api.PodRunning && isPodReady(&pod) {
+			ready++
+		}
+	}
+	return ready, nil
+}
@@ -0,0 +1,221 @@
+package sources
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
+	"github.com/golang/glog"
+)
+
+var (
+	argGracefulScaleDown = flag.Bool("graceful_scale_down", false, "Suspend and remove the EAP pods with the fewest active sessions on scale down")
+	argSessionSubsystem  = flag.String("session_subsystem", "undertow", "EAP subsystem holding deployment sessions (undertow or web)")
+	argSuspendTimeout    = flag.Duration("suspend_timeout", 30*time.Second, "How long to wait for in-flight requests of a suspended EAP pod")
+)
+
+// resumeTimeout bounds resuming a pod we could not delete.
+const resumeTimeout = 5 * time.Second
+
+type DmrOperationRequest struct {
+	Operation string   `json:"operation"`
+	Address   []string `json:"address"`
+	Timeout   int      `json:"timeout,omitempty"`
+	Pretty    int      `json:"json.pretty"`
+}
+
+// DmrStepResult is a single result of a wildcard address read.
+type DmrStepResult struct {
+	Address []map[string]string `json:"address"`
+	Outcome string              `json:"outcome"`
+	Result  SessionResult       `json:"result"`
+}
+
+type SessionResult struct {
+	ActiveSessions StringInt `json:"active-sessions"`
+}
+
+// checkSessions sums the active sessions of all deployments in this container.
+func (self *DmrContainer) checkSessions(ctx context.Context, requestCountData *RequestCountData) error {
+	dmrRequest := DmrResourceRequest{
+		Operation:      "read-resource",
+		IncludeRuntime: true,
+		Address:        []string{"deployment", "*", "subsystem", *argSessionSubsystem},
+		Pretty:         1,
+	}
+
+	results := []DmrStepResult{}
+	dmrResponse := DmrResponse{
+		Result: &results,
+	}
+
+	err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+	if err != nil {
+		return err
+	}
+	if dmrResponse.Outcome != "success" {
+		return fmt.Errorf("Cannot read active sessions: %s", dmrResponse.FailureDescription)
+	}
+
+	sessions := 0
+	for _, result := range results {
+		if result.Outcome == "success" {
+			sessions += result.Result.ActiveSessions.Value
+		}
+	}
+
+	requestCountData.containers[self.Pod.ID] = self
+	requestCountData.sessions[self.Pod.ID] += sessions
+
+	return nil
+}
+
+// suspend stops the container from accepting new requests, in-flight ones are allowed to finish.
+func (self *DmrContainer) suspend(ctx context.Context, timeout time.Duration) error {
+	dmrRequest := DmrOperationRequest{
+		Operation: "suspend",
+		Address:   []string{},
+		Timeout:   int(timeout.Seconds()),
+		Pretty:    1,
+	}
+
+	dmrResponse := DmrResponse{}
+	err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+	if err != nil {
+		return err
+	}
+	if dmrResponse.Outcome != "success" {
+		return fmt.Errorf("Cannot suspend %s: %s", self.Pod.Name, dmrResponse.FailureDescription)
+	}
+
+	return nil
+}
+
+// resume lets a suspended container accept requests again.
+func (self *DmrContainer) resume(ctx context.Context) error {
+	dmrRequest := DmrOperationRequest{
+		Operation: "resume",
+		Address:   []string{},
+		Pretty:    1,
+	}
+
+	dmrResponse := DmrResponse{}
+	err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+	if err != nil {
+		return err
+	}
+	if dmrResponse.Outcome != "success" {
+		return fmt.Errorf("Cannot resume %s: %s", self.Pod.Name, dmrResponse.FailureDescription)
+	}
+
+	return nil
+}
+
+func (self *DmrContainer) isSuspended(ctx context.Context) (bool, error) {
+	dmrRequest := DmrAttributeRequest{
+		Operation: "read-attribute",
+		Name:      "suspend-state",
+		Pretty:    1,
+	}
+
+	state := ""
+	dmrResponse := DmrResponse{
+		Result: &state,
+	}
+
+	err := self.getStats(ctx, &dmrRequest, &dmrResponse)
+	if err != nil {
+		return false, err
+	}
+
+	return state == "SUSPENDED", nil
+}
+
+type bySessions struct {
+	uids     []types.UID
+	sessions map[types.UID]int
+}
+
+func (self bySessions) Len() int      { return len(self.uids) }
+func (self bySessions) Swap(i, j int) { self.uids[i], self.uids[j] = self.uids[j], self.uids[i] }
+func (self bySessions) Less(i, j int) bool {
+	return self.sessions[self.uids[i]] < self.sessions[self.uids[j]]
+}
+
+// pickIdlePods returns the n containers with the fewest active sessions.
+func (self *RequestCountData) pickIdlePods(n int) []*DmrContainer {
+	uids := make([]types.UID, 0, len(self.containers))
+	for uid := range self.containers {
+		uids = append(uids, uid)
+	}
+	sort.Sort(bySessions{uids: uids, sessions: self.sessions})
+
+	picked := make([]*DmrContainer, 0, n)
+	for i := 0; i < n && i < len(uids); i++ {
+		picked = append(picked, self.containers[uids[i]])
+	}
+	return picked
+}
+
+// scaleDown suspends the least loaded pods, waits for their in-flight requests and deletes
+// them before lowering the replicas, so the replication controller does not pick random ones.
+// Pods beyond the ones whose sessions were read are left to the replication controller.
+func (self *RequestCountData) scaleDown(ctx context.Context, client *KubeClient, current int, decision Decision) error {
+	victims := self.pickIdlePods(current - decision.Replicas)
+
+	for _, container := range victims {
+		glog.Infof("Suspending pod %s with %d active sessions", container.Pod.Name, self.sessions[container.Pod.ID])
+		err := container.suspend(ctx, *argSuspendTimeout)
+		if err != nil {
+			glog.Errorf("Error suspending pod %s: %s", container.Pod.Name, err)
+		}
+	}
+
+	// on shutdown stop waiting, but still remove the suspended pods so they do not linger
+	deadline := time.Now().Add(*argSuspendTimeout)
+	for _, container := range victims {
+		for time.Now().Before(deadline) && ctx.Err() == nil {
+			suspended, err := container.isSuspended(ctx)
+			if err != nil || suspended {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+	}
+
+	// delete first, replacements the RC might still create are pending and get removed first
+	var deleteErr error
+	for i, container := range victims {
+		glog.Infof("Deleting suspended pod %s", container.Pod.Name)
+		deleteErr = client.DeletePod(container.Pod.Name)
+		if deleteErr != nil {
+			// the pods we could not delete would refuse requests for good
+			glog.Errorf("Error deleting pod %s: %s", container.Pod.Name, deleteErr)
+			self.resume(victims[i:])
+			break
+		}
+	}
+
+	_, err := client.SetReplicas(decision.Controller, decision.Replicas)
+	if err != nil {
+		return err
+	}
+	return deleteErr
+}
+
+// resume puts suspended containers back into service. It does not give up on shutdown, pods left
+// suspended would refuse requests for good.
+func (self *RequestCountData) resume(containers []*DmrContainer) {
+	for _, container := range containers {
+		glog.Infof("Resuming pod %s", container.Pod.Name)
+		ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+		err := container.resume(ctx)
+		cancel()
+		if err != nil {
+			glog.Errorf("Error resuming pod %s: %s", container.Pod.Name, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package sources
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// scheduleAnnotation holds the scheduled replica floors of a target replication controller, e.g.
+// [{"name":"monday","cron":"0 8 * * 1","timezone":"Europe/Prague","duration":"3h","min":6}]
+const scheduleAnnotation = "ascaler/schedule"
+
+// maxScheduleDuration bounds how far back a window start is looked for.
+const maxScheduleDuration = 31 * 24 * time.Hour
+
+// ScheduleEntry is a window opened by a cron expression, during which the replicas are kept within min and max.
+type ScheduleEntry struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"` // defaults to UTC
+	Duration string `json:"duration"`
+	Min      int    `json:"min"`
+	Max      int    `json:"max,omitempty"` // 0 is unlimited
+
+	cron     *CronExpression
+	location *time.Location
+	duration time.Duration
+}
+
+// ActiveSchedule is the combination of the windows currently open on a target.
+type ActiveSchedule struct {
+	Names []string  `json:"names"`
+	Min   int       `json:"min"`
+	Max   int       `json:"max,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+func (self *ActiveSchedule) String() string {
+	if self.Max > 0 {
+		return fmt.Sprintf("%s: %v to %v replicas", strings.Join(self.Names, ", "), self.Min, self.Max)
+	}
+	return fmt.Sprintf("%s: at least %v replicas", strings.Join(self.Names, ", "), self.Min)
+}
+
+func parseSchedule(value string) ([]*ScheduleEntry, error) {
+	entries := make([]*ScheduleEntry, 0)
+	err := json.Unmarshal([]byte(value), &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entry.cron, err = parseCron(entry.Cron)
+		if err != nil {
+			return nil, err
+		}
+		entry.location, err = time.LoadLocation(entry.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		entry.duration, err = time.ParseDuration(entry.Duration)
+		if err != nil {
+			return nil, err
+		}
+		if entry.duration <= 0 || entry.duration > maxScheduleDuration {
+			return nil, fmt.Errorf("Duration of schedule %s must be between 0 and %v", entry.Name, maxScheduleDuration)
+		}
+		if entry.Min < 0 || (entry.Max > 0 && entry.Max < entry.Min) {
+			return nil, fmt.Errorf("Invalid replicas of schedule %s", entry.Name)
+		}
+	}
+	return entries, nil
+}
+
+// getSchedule reads the scheduled floors from a replication controller's annotations, none if it has no schedule.
+func getSchedule(annotations map[string]string) ([]*ScheduleEntry, error) {
+	value, found := annotations[scheduleAnnotation]
+	if !found {
+		return nil, nil
+	}
+	entries, err := parseSchedule(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s annotation: %s", scheduleAnnotation, err)
+	}
+	return entries, nil
+}
+
+// activeSchedule combines the open windows: the highest floor and the lowest ceiling win.
+func activeSchedule(entries []*ScheduleEntry, now time.Time) *ActiveSchedule {
+	var active *ActiveSchedule
+	for _, entry := range entries {
+		since, open := entry.cron.FiredWithin(now.In(entry.location), entry.duration)
+		if !open {
+			continue
+		}
+		if active == nil {
+			active = &ActiveSchedule{Names: []string{}, Since: since}
+		}
+		active.Names = append(active.Names, entry.Name)
+		if entry.Min > active.Min {
+			active.Min = entry.Min
+		}
+		if entry.Max > 0 && (active.Max == 0 || entry.Max < active.Max) {
+			active.Max = entry.Max
+		}
+		if since.Before(active.Since) {
+			active.Since = since
+		}
+	}
+	if active != nil && active.Max > 0 && active.Max < active.Min {
+		active.Max = active.Min // overlapping windows disagree, the floor wins
+	}
+	return active
+}
+
+// ScheduleTracker keeps a target's replicas within its scheduled floors, and reports open and closed windows.
+type ScheduleTracker struct {
+	entries []*ScheduleEntry
+	current *ActiveSchedule
+}
+
+// Apply returns the replicas within the active schedule, i.e. max(replicas, floor) capped by the ceiling.
+// The annotations are the controller's, as read for the decision.
+func (self *ScheduleTracker) Apply(client *KubeClient, name string, annotations map[string]string, replicas int) int {
+	entries, err := getSchedule(annotations)
+	if err != nil {
+		glog.Errorf("Error reading schedule of %s: %s", name, err)
+		entries = self.entries // keep what we knew
+	}
+	self.entries = entries
+
+	active := activeSchedule(entries, time.Now())
+	if active != nil && self.current == nil {
+		client.RecordEvent(name, "ScheduleStarted", fmt.Sprintf("Scheduled replicas %s", active))
+	} else if active == nil && self.current != nil {
+		client.RecordEvent(name, "ScheduleEnded", fmt.Sprintf("Schedule %s ended", strings.Join(self.current.Names, ", ")))
+	} else if active != nil && active.String() != self.current.String() {
+		client.RecordEvent(name, "ScheduleChanged", fmt.Sprintf("Scheduled replicas %s", active))
+	}
+	self.current = active
+
+	if active == nil {
+		return replicas
+	}
+	if replicas < active.Min {
+		glog.Infof("Raising replicas of %s from %v to the scheduled %s", name, replicas, active)
+		replicas = active.Min
+	}
+	if active.Max > 0 && replicas > active.Max {
+		glog.Infof("Lowering replicas of %s from %v to the scheduled %s", name, replicas, active)
+		replicas = active.Max
+	}
+	return replicas
+}
@@ -0,0 +1,167 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	kube_labels "github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/golang/glog"
+)
+
+var (
+	argEapService = flag.String("eap_service", "", "EAP service to scale across all replication controllers whose pods it selects, instead of eap_replication_controller")
+	argRcWeights  = flag.String("rc_weights", "", "Comma separated controller=weight list splitting a service's replicas, defaults to 1, 0 leaves a controller alone")
+)
+
+// ServiceTarget scales all replication controllers behind a service, e.g. a blue/green pair,
+// splitting the replicas computed from all of their pods by weight.
+type ServiceTarget struct {
+	name        string
+	selector    map[string]string       // the service's pod selector
+	annotations map[string]string       // of the service, as of the last refresh
+	weights     map[string]int          // controller --> weight
+	targets     map[string]*ScaleTarget // discovered controllers
+}
+
+func newServiceTarget(client *KubeClient, name string) (*ServiceTarget, error) {
+	service, err := client.client.Services(*argNamespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(service.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("Service %s has no pod selector", name)
+	}
+
+	weights := make(map[string]int)
+	for rc, value := range parseKeyValues(*argRcWeights) {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("Invalid weight for controller %s: %s", rc, value)
+		}
+		weights[rc] = weight
+	}
+
+	return &ServiceTarget{
+		name:        name,
+		selector:    service.Spec.Selector,
+		annotations: service.Annotations,
+		weights:     weights,
+		targets:     make(map[string]*ScaleTarget),
+	}, nil
+}
+
+// Selector returns the label selector of the service's pods.
+func (self *ServiceTarget) Selector() string {
+	return kube_labels.SelectorFromSet(self.selector).String()
+}
+
+func (self *ServiceTarget) weight(rc string) int {
+	if weight, found := self.weights[rc]; found {
+		return weight
+	}
+	return 1
+}
+
+// Refresh re-reads the service's annotations, discovers the replication controllers whose pods it
+// selects, and returns the ones with a weight, sorted by name.
+func (self *ServiceTarget) Refresh(client *KubeClient) ([]*ScaleTarget, error) {
+	service, err := client.client.Services(*argNamespace).Get(self.name)
+	if err != nil {
+		return nil, err
+	}
+	self.annotations = service.Annotations // the pods keep being scraped by the selector we started with
+
+	list, err := client.client.ReplicationControllers(*argNamespace).List(kube_labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	sc := kube_labels.SelectorFromSet(self.selector)
+	discovered := make(map[string]*ScaleTarget)
+	names := make([]string, 0)
+	for _, rc := range list.Items {
+		if rc.Spec.Template == nil || !sc.Matches(kube_labels.Set(rc.Spec.Template.Labels)) || self.weight(rc.Name) == 0 {
+			continue
+		}
+		target, found := self.targets[rc.Name]
+		if !found {
+			glog.Infof("Service %s is backed by controller %s, weight %v", self.name, rc.Name, self.weight(rc.Name))
+			target = newScaleTarget(client, rc.Name, kube_labels.SelectorFromSet(rc.Spec.Selector).String())
+		}
+		discovered[rc.Name] = target
+		names = append(names, rc.Name)
+	}
+	self.targets = discovered
+
+	sort.Strings(names)
+	targets := make([]*ScaleTarget, 0, len(names))
+	for _, name := range names {
+		targets = append(targets, discovered[name])
+	}
+	return targets, nil
+}
+
+// Split divides the total replicas between the controllers by weight, using the largest remainders
+// for what does not divide evenly. Every controller keeps at least one replica, as long as the total
+// has one for each.
+func (self *ServiceTarget) Split(total int, targets []*ScaleTarget) []int {
+	shares := make([]int, len(targets))
+	sum := 0
+	for _, target := range targets {
+		sum += self.weight(target.name)
+	}
+	if sum == 0 {
+		return shares
+	}
+
+	remainders := make([]int, len(targets))
+	assigned := 0
+	for i, target := range targets {
+		exact := total * self.weight(target.name)
+		shares[i] = exact / sum
+		remainders[i] = exact % sum
+		assigned += shares[i]
+	}
+
+	order := make([]int, len(targets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; assigned < total; i++ {
+		shares[order[i%len(order)]]++
+		assigned++
+	}
+
+	// the floor takes its pod from the largest share, so the shares still add up to the total
+	for _, i := range order {
+		if shares[i] > 0 {
+			continue
+		}
+		largest := 0
+		for j := range shares {
+			if shares[j] > shares[largest] {
+				largest = j
+			}
+		}
+		if shares[largest] <= 1 {
+			break // fewer replicas than controllers
+		}
+		shares[largest]--
+		shares[i]++
+	}
+	return shares
+}
+
+// ownerOf returns the target controller selecting pods with the labels.
+func (self *RequestCountData) ownerOf(labels kube_labels.Set) string {
+	for _, target := range self.targets {
+		sc, err := kube_labels.Parse(target.selector)
+		if err == nil && sc.Matches(labels) {
+			return target.name
+		}
+	}
+	return *eapReplicationController
+}
@@ -0,0 +1,148 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var argShadowPolicies = flag.String("shadow_policies", "", "Comma separated policies evaluated next to the active one without scaling, e.g. utilization,pid")
+
+// shadowPoliciesAnnotation lists the shadow policies on the replication controller, or on the service
+// when scaling by service, e.g. pid,expression
+const shadowPoliciesAnnotation = "ascaler/shadow-policies"
+
+var policyReplicasGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "ascaler",
+	Name:      "policy_replicas",
+	Help:      "Replicas recommended by the active policy and by the shadow policies.",
+}, []string{"target", "policy", "role"})
+
+func init() {
+	prometheus.MustRegister(policyReplicasGauge)
+}
+
+func parseShadowPolicies(value string) ([]string, error) {
+	kinds := make([]string, 0)
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if err := validatePolicyKind(kind); err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+func validateShadowPolicies() error {
+	_, err := parseShadowPolicies(*argShadowPolicies)
+	if err != nil {
+		return fmt.Errorf("Invalid shadow_policies: %s", err)
+	}
+	return nil
+}
+
+// ShadowPolicies are evaluated on the observations of the active policy, but never scale. Their state
+// starts afresh and is not checkpointed, so they cannot disturb the active policy.
+type ShadowPolicies struct {
+	owner    PolicyOwner
+	policies map[string]Policy
+	last     map[string]int // replicas each one recommended on the last poll
+}
+
+func newShadowPolicies(owner PolicyOwner) *ShadowPolicies {
+	return &ShadowPolicies{
+		owner:    owner,
+		policies: make(map[string]Policy),
+		last:     make(map[string]int),
+	}
+}
+
+// refresh follows the shadow policies configured on the owner, keeping the state of the ones that stay.
+// The annotations are the ones read with the owner on the last poll, so this costs no API call.
+func (self *ShadowPolicies) refresh(annotations map[string]string) {
+	value := *argShadowPolicies
+	if annotation, found := annotations[shadowPoliciesAnnotation]; found {
+		value = annotation
+	}
+	kinds, err := parseShadowPolicies(value)
+	if err != nil {
+		glog.Errorf("Invalid shadow policies of %s: %s", self.owner.name, err)
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, kind := range kinds {
+		wanted[kind] = true
+		if self.policies[kind] == nil {
+			glog.Infof("Evaluating the %s policy in the shadow of %s", kind, self.owner.name)
+			self.policies[kind] = createPolicy(kind, self.owner, &ControllerState{})
+		}
+	}
+	for kind := range self.policies {
+		if !wanted[kind] {
+			delete(self.policies, kind)
+			delete(self.last, kind)
+			policyReplicasGauge.DeleteLabelValues(self.owner.name, kind, "shadow")
+		}
+	}
+}
+
+// Evaluate asks every shadow policy for its replicas on the observation the active policy sees.
+func (self *ShadowPolicies) Evaluate(observation Observation) {
+	self.refresh(observation.Annotations)
+	for kind, policy := range self.policies {
+		replicas := policy.Replicas(observation)
+		self.last[kind] = replicas
+		policyReplicasGauge.WithLabelValues(self.owner.name, kind, "shadow").Set(float64(replicas))
+	}
+}
+
+// Report logs and exports the final decision next to what the shadow policies recommended.
+func (self *ShadowPolicies) Report(active int) {
+	policyReplicasGauge.WithLabelValues(self.owner.name, *argPolicy, "active").Set(float64(active))
+	if len(self.policies) == 0 {
+		return
+	}
+
+	kinds := make([]string, 0, len(self.policies))
+	for kind := range self.policies {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	recommended := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		recommended = append(recommended, fmt.Sprintf("%s %v", kind, self.last[kind]))
+	}
+	glog.Infof("Decided on %v replicas of %s with the %s policy, shadow policies: %s", active, self.owner.name,
+		*argPolicy, strings.Join(recommended, ", "))
+}
+
+// usesHeap tells if a shadow policy needs the heap of the pods.
+func (self *ShadowPolicies) usesHeap() bool {
+	for _, policy := range self.policies {
+		if policyUsesHeap(policy) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recommended returns what the shadow policies recommended on the last poll.
+func (self *ShadowPolicies) Recommended() map[string]int {
+	if len(self.last) == 0 {
+		return nil
+	}
+	recommended := make(map[string]int)
+	for kind, replicas := range self.last {
+		recommended[kind] = replicas
+	}
+	return recommended
+}
@@ -0,0 +1,143 @@
+package sources
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	argRecord   = flag.String("record", "", "Append every observation to this CSV file, to replay it with -simulate")
+	argSimulate = flag.String("simulate", "", "Replay the observations recorded in this CSV file through the policy, print the replicas and exit")
+)
+
+// recordObservation appends the observation to the record file: time, rate, latency, pods, replicas, heap.
+func recordObservation(observation Observation) error {
+	if *argRecord == "" {
+		return nil
+	}
+	file, err := os.OpenFile(*argRecord, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%d,%.3f,%.3f,%d,%d,%.3f\n", observation.At.Unix(),
+		observation.Rate, observation.Latency, observation.Pods, observation.Replicas, observation.Heap)
+	return err
+}
+
+func readObservations(in io.Reader) ([]Observation, error) {
+	reader := csv.NewReader(bufio.NewReader(in))
+	reader.FieldsPerRecord = -1 // the heap came later
+	observations := make([]Observation, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return observations, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) != 5 && len(record) != 6 {
+			return nil, fmt.Errorf("Invalid observation %v, expected 5 or 6 fields", record)
+		}
+		values := make([]float64, 6)
+		for i, field := range record {
+			values[i], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid observation %v: %s", record, err)
+			}
+		}
+		observations = append(observations, Observation{
+			At:       time.Unix(int64(values[0]), 0),
+			Rate:     values[1],
+			Latency:  values[2],
+			Pods:     int(values[3]),
+			Replicas: int(values[4]),
+			Heap:     values[5],
+		})
+	}
+}
+
+// Simulating tells if we only replay recorded traffic.
+func Simulating() bool {
+	return *argSimulate != ""
+}
+
+// Simulate replays recorded traffic through the configured policy to tune it. The replicas it asks for
+// serve a start-up time later. Latency is assumed to grow with the load per pod, since the recording
+// only tells it for the pods that served it.
+func Simulate(out io.Writer) error {
+	err := validatePolicy()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(*argSimulate)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	observations, err := readObservations(file)
+	if err != nil {
+		return err
+	}
+	if len(observations) == 0 {
+		return fmt.Errorf("No observations in %s", *argSimulate)
+	}
+
+	type pending struct {
+		at       time.Time
+		replicas int
+	}
+	policy := newPolicy(PolicyOwner{name: *argSimulate}, &ControllerState{})
+	replicas := observations[0].Replicas
+	serving := observations[0].Pods
+	scheduled := make([]pending, 0)
+
+	fmt.Fprintln(out, "time,rate,latency,utilization,serving,replicas")
+	var replicaSeconds, overloaded float64
+	for i, recorded := range observations {
+		for len(scheduled) > 0 && !scheduled[0].at.After(recorded.At) {
+			serving = scheduled[0].replicas
+			scheduled = scheduled[1:]
+		}
+		if replicas < serving {
+			serving = replicas // scaling down is immediate
+		}
+
+		simulated := recorded
+		simulated.Pods = serving
+		simulated.Replicas = replicas
+		if serving > 0 && recorded.Pods > 0 {
+			simulated.Latency = recorded.Latency * float64(recorded.Pods) / float64(serving)
+		}
+
+		wanted := policy.Replicas(simulated)
+		if wanted != replicas {
+			scheduled = append(scheduled, pending{at: recorded.At.Add(*argStartupTime), replicas: wanted})
+			replicas = wanted
+		}
+
+		if i > 0 {
+			dt := recorded.At.Sub(observations[i-1].At).Seconds()
+			replicaSeconds += float64(serving) * dt
+			if simulated.Utilization() > 1 {
+				overloaded += dt
+			}
+		}
+		fmt.Fprintf(out, "%s,%.1f,%.1f,%.3f,%d,%d\n", recorded.At.Format(time.RFC3339),
+			recorded.Rate, simulated.Latency, simulated.Utilization(), serving, replicas)
+	}
+
+	fmt.Fprintf(out, "# %v observations, %.1f pod hours, overloaded for %v\n",
+		len(observations), replicaSeconds/3600, time.Duration(overloaded)*time.Second)
+	return nil
+}
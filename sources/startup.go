@@ -0,0 +1,114 @@
+package sources
+
+import (
+	"flag"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
+	"github.com/golang/glog"
+)
+
+var (
+	argStartupTime  = flag.Duration("startup_time", 90*time.Second, "How long new pods take to serve, until it is learned from the pods")
+	argTrendScaling = flag.Bool("trend_scaling", false, "Scale for the rate extrapolated one start-up time ahead, if higher than the current one")
+	argTrendWindow  = flag.Duration("trend_window", 5*time.Minute, "How much rate history the trend is fitted to")
+)
+
+// startupWeight is the weight of the latest start-up time in its moving average.
+const startupWeight = 0.3
+
+// StartupTracker learns how long pods take from creation to being ready.
+type StartupTracker struct {
+	started  time.Time          // pods created before we started are not measured
+	measured map[types.UID]bool // pods whose start-up time we learned
+	learned  time.Duration      // moving average, 0 until a pod was measured
+}
+
+func newStartupTracker(learned time.Duration) *StartupTracker {
+	return &StartupTracker{started: time.Now(), measured: make(map[types.UID]bool), learned: learned}
+}
+
+// Lead is how long new pods take to serve, learned or assumed.
+func (self *StartupTracker) Lead() time.Duration {
+	if self.learned > 0 {
+		return self.learned
+	}
+	return *argStartupTime
+}
+
+// Observe learns the start-up time of a pod created since we started, from when we first saw it ready.
+// That is when it took its first requests, the warm-up and polling do not delay it.
+func (self *StartupTracker) Observe(pod Pod) {
+	if self.measured[pod.ID] || pod.Created.Before(self.started) || pod.Ready.IsZero() {
+		return
+	}
+	self.measured[pod.ID] = true
+
+	startup := pod.Ready.Sub(pod.Created)
+	if self.learned == 0 {
+		self.learned = startup
+	} else {
+		self.learned = time.Duration(startupWeight*float64(startup) + (1-startupWeight)*float64(self.learned))
+	}
+	glog.Infof("Pod %s was ready %v after its creation, start-up time is now %v", pod.Name, startup, self.learned)
+}
+
+// Forget drops the pods that are gone.
+func (self *StartupTracker) Forget(current map[types.UID]*InstanceData) {
+	for uid := range self.measured {
+		if _, found := current[uid]; !found {
+			delete(self.measured, uid)
+		}
+	}
+}
+
+type rateSample struct {
+	at   time.Time
+	rate float64
+}
+
+// RateTrend fits a line to the recent rates to extrapolate them.
+type RateTrend struct {
+	samples []rateSample
+}
+
+// Observe adds a rate and forgets the ones older than the trend window.
+func (self *RateTrend) Observe(at time.Time, rate float64) {
+	self.samples = append(self.samples, rateSample{at: at, rate: rate})
+	for len(self.samples) > 0 && at.Sub(self.samples[0].at) > *argTrendWindow {
+		self.samples = self.samples[1:]
+	}
+}
+
+// Project extrapolates the rate by the least squares slope of the window, never below zero.
+func (self *RateTrend) Project(ahead time.Duration) float64 {
+	n := float64(len(self.samples))
+	if n == 0 {
+		return 0
+	}
+	last := self.samples[len(self.samples)-1]
+	if n < 2 {
+		return last.rate
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range self.samples {
+		x := sample.at.Sub(last.at).Seconds()
+		sumX += x
+		sumY += sample.rate
+		sumXY += x * sample.rate
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return last.rate
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n // the fitted rate now
+
+	projected := intercept + slope*ahead.Seconds()
+	if projected < 0 {
+		return 0
+	}
+	return projected
+}
@@ -0,0 +1,88 @@
+package sources
+
+import (
+	"encoding/json"
+	"flag"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/types"
+	"github.com/golang/glog"
+)
+
+var argCheckpointInterval = flag.Duration("checkpoint_interval", time.Minute, "How often the controller state is saved to the replication controller")
+
+// stateAnnotation holds the checkpointed controller state on the target replication controller.
+const stateAnnotation = "ascaler/state"
+
+// ControllerState is what a restarted ascaler needs to carry on without guessing.
+type ControllerState struct {
+	Replicas       int                         `json:"replicas"`                 // last replicas we decided on
+	LastDecision   time.Time                   `json:"lastDecision"`             // last time we calculated replicas
+	LastScale      time.Time                   `json:"lastScale,omitempty"`      // last time we changed replicas
+	Pods           map[types.UID]*InstanceData `json:"pods,omitempty"`           // request count baselines
+	Checkpointed   time.Time                   `json:"checkpointed,omitempty"`   // when this state was saved
+	Manual         *ManualScale                `json:"manual,omitempty"`         // replicas set outside ascaler
+	Forecast       *HoltWinters                `json:"forecast,omitempty"`       // learned rate history
+	StartupTime    time.Duration               `json:"startupTime,omitempty"`    // learned pod start-up time
+	PID            *PIDState                   `json:"pid,omitempty"`            // pid policy controller state
+	Rules          *StepRulesState             `json:"rules,omitempty"`          // step rules breaches and cooldowns
+	Utilization    []Recommendation            `json:"utilization,omitempty"`    // utilization policy downscale stabilization
+	WebhookChanged time.Time                   `json:"webhookChanged,omitempty"` // webhook policy's last replica change, for its cooldown
+	Flap           *FlapState                  `json:"flap,omitempty"`           // scaling direction changes and damping
+}
+
+// LoadState reads the checkpointed state of a replication controller. A missing checkpoint is not an
+// error, the state then only holds the controller's current replicas so we do not scale on the first poll.
+func (self *KubeClient) LoadState(name string) (*ControllerState, error) {
+	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &ControllerState{}
+	if value, found := rc.Annotations[stateAnnotation]; found {
+		err = json.Unmarshal([]byte(value), state)
+		if err != nil {
+			glog.Warningf("Ignoring invalid state checkpoint on %s: %s", name, err)
+			state = &ControllerState{}
+		}
+	}
+
+	if state.Replicas != rc.Spec.Replicas {
+		glog.Infof("Replicas of %s changed from %v to %v since the last checkpoint", name, state.Replicas, rc.Spec.Replicas)
+		state.Replicas = rc.Spec.Replicas
+	}
+
+	return state, nil
+}
+
+// SaveState checkpoints the state into an annotation of the replication controller.
+func (self *KubeClient) SaveState(name string, state *ControllerState) error {
+	if !self.canActuate() {
+		return nil
+	}
+
+	rc, err := self.client.ReplicationControllers(*argNamespace).Get(name)
+	if err != nil {
+		return err
+	}
+
+	state.Checkpointed = time.Now()
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if rc.Annotations == nil {
+		rc.Annotations = make(map[string]string)
+	}
+	rc.Annotations[stateAnnotation] = string(value)
+
+	_, err = self.client.ReplicationControllers(*argNamespace).Update(rc)
+	return err
+}
+
+// needsCheckpoint tells if the state should be saved, either because we are told to or it is getting old.
+func needsCheckpoint(state *ControllerState, force bool) bool {
+	return force || time.Since(state.Checkpointed) >= *argCheckpointInterval
+}
@@ -0,0 +1,133 @@
+package sources
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	argStatusAddress = flag.String("status_address", "", "Address (e.g. :8080) to serve the JSON status API on, disabled if empty")
+	argStatusToken   = flag.String("status_token", "", "File holding the bearer token the target controls of the status API require, disabled if empty")
+)
+
+// TargetStatus is what the status API reports about a scaled replication controller.
+type TargetStatus struct {
+	ReplicationController string          `json:"replicationController"`
+	Service               string          `json:"service,omitempty"`
+	DependsOn             string          `json:"dependsOn,omitempty"`
+	Replicas              int             `json:"replicas"`
+	LastUpdate            time.Time       `json:"lastUpdate"`
+	CappedBy              string          `json:"cappedBy,omitempty"`
+	Booting               int             `json:"booting"`
+	Pending               int             `json:"pending"`
+	Constrained           bool            `json:"capacityConstrained"`
+	Override              Override        `json:"override"`
+	Schedule              *ActiveSchedule `json:"schedule,omitempty"`
+	Manual                *ManualScale    `json:"manualScale,omitempty"`
+	Conflict              string          `json:"conflict,omitempty"`
+	Rollout               bool            `json:"rolloutInProgress"`
+	Recommended           int             `json:"recommendedReplicas"`
+	Forecast              *ForecastStatus `json:"forecast,omitempty"`
+	StartupTime           string          `json:"startupTime,omitempty"`
+	ProjectedRate         float64         `json:"projectedRate"`
+	PolicyReason          string          `json:"policyReason,omitempty"`
+	ShadowPolicies        map[string]int  `json:"shadowPolicies,omitempty"`
+	Flaps                 int             `json:"flaps"`
+	MetricsCoverage       float64         `json:"metricsCoverage"`
+	LastCompleteMetrics   time.Time       `json:"lastCompleteMetrics"`
+	SafeMode              string          `json:"safeMode,omitempty"` // why, when in safe mode
+	FlapDamping           int             `json:"flapDamping,omitempty"`
+	Pods                  []PodHealth     `json:"pods"`
+}
+
+// StatusRegistry holds the latest status of every target, shared between the control loop and the API.
+type StatusRegistry struct {
+	lock    sync.RWMutex
+	targets map[string]TargetStatus
+	managed map[string]bool // replication controllers the target controls may change
+	client  *KubeClient     // for the target controls
+	token   string          // the target controls require, if any
+}
+
+var statusRegistry = &StatusRegistry{targets: make(map[string]TargetStatus), managed: make(map[string]bool)}
+
+func (self *StatusRegistry) SetClient(client *KubeClient) {
+	self.client = client
+}
+
+// Manage allows the target controls on a replication controller we scale.
+func (self *StatusRegistry) Manage(name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.managed[name] = true
+}
+
+// Replace moves the target controls and status from a replaced controller to the one that replaced it.
+func (self *StatusRegistry) Replace(old, name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.managed, old)
+	delete(self.targets, old)
+	self.managed[name] = true
+}
+
+func (self *StatusRegistry) isManaged(name string) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.managed[name]
+}
+
+func (self *StatusRegistry) Publish(status TargetStatus) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	status.LastUpdate = time.Now()
+	self.targets[status.ReplicationController] = status
+}
+
+func (self *StatusRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(self.targets)
+	if err != nil {
+		glog.Errorf("Error writing status: %s", err)
+	}
+}
+
+// StartStatusServer serves the status API in the background, if an address is configured.
+func StartStatusServer() error {
+	if *argStatusAddress == "" {
+		return nil
+	}
+	if *argStatusToken != "" {
+		token, err := ioutil.ReadFile(*argStatusToken)
+		if err != nil {
+			return err
+		}
+		statusRegistry.token = strings.TrimSpace(string(token))
+		if statusRegistry.token == "" {
+			return fmt.Errorf("Empty status_token in %s", *argStatusToken)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/status", statusRegistry)
+	mux.HandleFunc("/targets/", statusRegistry.serveTargets)
+	mux.Handle("/metrics", prometheus.Handler())
+	go func() {
+		glog.Infof("Serving status API on %s", *argStatusAddress)
+		err := http.ListenAndServe(*argStatusAddress, mux)
+		if err != nil {
+			glog.Errorf("Status API stopped: %s", err)
+		}
+	}()
+	return nil
+}
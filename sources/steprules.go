@@ -0,0 +1,190 @@
+package sources
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var argStepRules = flag.String("step_rules", "", "JSON step scaling rules of targets without an ascaler/step-rules annotation")
+
+// stepRulesAnnotation holds the step scaling rules on the replication controller, or on the service
+// when scaling by service, e.g. [{"name":"up","metric":"utilization","above":1.2,"for":2,"add":3}]
+const stepRulesAnnotation = "ascaler/step-rules"
+
+// StepRule adds (or removes) pods when a metric stays beyond a threshold for a number of polls.
+type StepRule struct {
+	Name     string   `json:"name"`
+	Metric   string   `json:"metric"` // utilization, rate or latency
+	Above    *float64 `json:"above,omitempty"`
+	Below    *float64 `json:"below,omitempty"`
+	For      int      `json:"for"` // consecutive polls in breach
+	Add      int      `json:"add"` // pods to add, negative to remove
+	Cooldown string   `json:"cooldown,omitempty"`
+
+	cooldown time.Duration
+}
+
+func parseStepRules(value string) ([]*StepRule, error) {
+	rules := make([]*StepRule, 0)
+	err := json.Unmarshal([]byte(value), &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" || names[rule.Name] {
+			return nil, fmt.Errorf("Step rules need unique names")
+		}
+		names[rule.Name] = true
+		if rule.Metric != "utilization" && rule.Metric != "rate" && rule.Metric != "latency" {
+			return nil, fmt.Errorf("Invalid metric of step rule %s: %s", rule.Name, rule.Metric)
+		}
+		if (rule.Above == nil) == (rule.Below == nil) {
+			return nil, fmt.Errorf("Step rule %s needs either above or below", rule.Name)
+		}
+		if rule.Add == 0 {
+			return nil, fmt.Errorf("Step rule %s does not add or remove pods", rule.Name)
+		}
+		if rule.For < 1 {
+			rule.For = 1
+		}
+		if rule.Cooldown != "" {
+			rule.cooldown, err = time.ParseDuration(rule.Cooldown)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid cooldown of step rule %s: %s", rule.Name, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func validateStepRules() error {
+	if *argStepRules == "" {
+		return nil
+	}
+	_, err := parseStepRules(*argStepRules)
+	return err
+}
+
+// value reads the rule's metric from the observation.
+func (self *StepRule) value(observation Observation) float64 {
+	switch self.Metric {
+	case "rate":
+		return observation.Rate
+	case "latency":
+		return observation.Latency
+	}
+	return observation.Utilization()
+}
+
+func (self *StepRule) breached(observation Observation) bool {
+	value := self.value(observation)
+	if self.Above != nil {
+		return value > *self.Above
+	}
+	return value < *self.Below
+}
+
+// StepRulesState is what the step rules carry from one poll to the next, and across restarts.
+type StepRulesState struct {
+	Breaches map[string]int       `json:"breaches,omitempty"` // consecutive polls in breach, per rule
+	Fired    map[string]time.Time `json:"fired,omitempty"`    // last time each rule fired
+}
+
+// StepRulesPolicy applies the step rules of a target. Of the rules firing on a poll the one adding the most
+// pods wins, so a scale up beats a scale down and the gentlest scale down is made.
+type StepRulesPolicy struct {
+	owner PolicyOwner
+	rules []*StepRule
+	state StepRulesState
+}
+
+func newStepRulesPolicy(owner PolicyOwner, state *StepRulesState) *StepRulesPolicy {
+	policy := &StepRulesPolicy{owner: owner}
+	if state != nil {
+		policy.state = *state
+	}
+	if policy.state.Breaches == nil {
+		policy.state.Breaches = make(map[string]int)
+	}
+	if policy.state.Fired == nil {
+		policy.state.Fired = make(map[string]time.Time)
+	}
+	return policy
+}
+
+// refresh follows the target's rules in the owner's annotations, keeping the previous ones if they are invalid.
+func (self *StepRulesPolicy) refresh(annotations map[string]string) {
+	value := *argStepRules
+	if annotation, found := annotations[stepRulesAnnotation]; found {
+		value = annotation
+	}
+	if value == "" {
+		self.rules = nil
+		return
+	}
+
+	rules, err := parseStepRules(value)
+	if err != nil {
+		glog.Errorf("Invalid step rules of %s: %s", self.owner.name, err)
+		return
+	}
+	self.rules = rules
+}
+
+func (self *StepRulesPolicy) Replicas(observation Observation) int {
+	self.refresh(observation.Annotations)
+
+	var fired *StepRule
+	for _, rule := range self.rules {
+		if !rule.breached(observation) {
+			self.state.Breaches[rule.Name] = 0
+			continue
+		}
+		self.state.Breaches[rule.Name]++
+		if self.state.Breaches[rule.Name] < rule.For {
+			continue
+		}
+		if last, found := self.state.Fired[rule.Name]; found && observation.At.Sub(last) < rule.cooldown {
+			glog.V(1).Infof("Step rule %s of %s is cooling down", rule.Name, self.owner.name)
+			continue
+		}
+		if fired == nil || rule.Add > fired.Add {
+			fired = rule
+		}
+	}
+
+	// forget rules that are gone
+	for name := range self.state.Breaches {
+		if !self.hasRule(name) {
+			delete(self.state.Breaches, name)
+		}
+	}
+	for name := range self.state.Fired {
+		if !self.hasRule(name) {
+			delete(self.state.Fired, name)
+		}
+	}
+
+	if fired == nil {
+		return clampReplicas(observation.Replicas)
+	}
+	self.state.Breaches[fired.Name] = 0
+	self.state.Fired[fired.Name] = observation.At
+	glog.Infof("Step rule %s of %s fired: %+d pods", fired.Name, self.owner.name, fired.Add)
+	return clampReplicas(observation.Replicas + fired.Add)
+}
+
+func (self *StepRulesPolicy) hasRule(name string) bool {
+	for _, rule := range self.rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package sources
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScaleTarget is a replication controller we scale, together with everything that can stand between
+// a decision and its replicas: other autoscalers, rollouts, manual changes, schedules and overrides.
+type ScaleTarget struct {
+	name            string // replication controller
+	selector        string // its pods
+	currentReplicas int    // how many replicas it currently has
+
+	state     *ControllerState // checkpointed across restarts
+	override  OverrideTracker  // manual pause or pinned replicas
+	schedule  ScheduleTracker  // scheduled replica floors
+	conflicts ConflictChecker  // other autoscalers of the same controller
+	rollout   RolloutTracker   // deployments replacing the pods
+
+	recommended int               // what we would have applied, when we may not actuate
+	annotations map[string]string // of the controller, as of the last decision
+}
+
+// Decision is what a target should do with the replicas we want.
+type Decision struct {
+	Replicas   int    // replicas to apply
+	Controller string // replication controller to apply them to
+	Changed    bool   // whether they differ from its current replicas
+}
+
+// newScaleTarget picks up the state a previous ascaler left behind on the controller.
+func newScaleTarget(client *KubeClient, name, selector string) *ScaleTarget {
+	target := &ScaleTarget{name: name, selector: selector, state: &ControllerState{}}
+	statusRegistry.Manage(name)
+	state, err := client.LoadState(name)
+	if err != nil {
+		glog.Warningf("Cannot restore state of %s: %s", name, err)
+		return target
+	}
+
+	target.state = state
+	target.currentReplicas = state.Replicas
+	glog.Infof("Restored state of %s: %v replicas, %v pod baselines, last decision at %v",
+		name, state.Replicas, len(state.Pods), state.LastDecision)
+	return target
+}
+
+// Decide runs the replicas we want past everything else that scales the controller.
+func (self *ScaleTarget) Decide(client *KubeClient, replicas int) (Decision, error) {
+	rollout := self.rollout.Check(client, self)
+
+	rc, err := client.GetController(self.name)
+	if err != nil {
+		return Decision{}, err
+	}
+	actual := rc.Spec.Replicas
+	self.annotations = rc.Annotations
+	hold := Decision{Replicas: actual, Controller: self.name}
+
+	actuate := true
+	conflict := self.conflicts.Check(client, self.name)
+	if conflict != "" {
+		if *argConflictMode == "refuse" {
+			glog.Warningf("Not scaling %s, it is also scaled by %s", self.name, conflict)
+			self.currentReplicas = actual
+			return hold, nil
+		}
+	} else if rollout != nil && rollout.InProgress {
+		// the deployer changes the replicas, that is not a manual change
+		actuate = *argRolloutMode == "follow"
+	} else if client.canActuate() {
+		replicas, actuate = self.state.checkManualScale(client, self.name, self.currentReplicas, actual, replicas)
+	}
+	self.currentReplicas = actual
+
+	replicas = self.schedule.Apply(client, self.name, rc.Annotations, replicas)
+	replicas, allowed := self.override.Apply(client, self.name, rc.Annotations, replicas)
+	self.recommended = replicas
+	if conflict != "" {
+		glog.Infof("Recommending %v replicas for %s, it is also scaled by %s", replicas, self.name, conflict)
+	}
+	if !actuate || !allowed || conflict != "" {
+		return hold, nil
+	}
+
+	if rollout != nil && rollout.InProgress {
+		glog.Infof("Applying %v replicas to %s, the newest controller of the rollout", replicas, rollout.Newest)
+		return Decision{Replicas: replicas, Controller: rollout.Newest, Changed: replicas != rollout.Replicas[rollout.Newest]}, nil
+	}
+	return Decision{Replicas: replicas, Controller: self.name, Changed: replicas != actual}, nil
+}
+
+// Applied records the outcome of a decision and checkpoints the state. Changed tells if the replicas were
+// written: a standby does not write them, and must not take them for the controller's.
+func (self *ScaleTarget) Applied(client *KubeClient, decision Decision) {
+	if decision.Changed && decision.Controller == self.name {
+		self.currentReplicas = decision.Replicas
+	}
+	self.state.LastDecision = time.Now()
+	if decision.Changed {
+		self.state.LastScale = self.state.LastDecision
+	}
+	self.checkpoint(client, decision.Changed)
+}
+
+// checkpoint saves the state if forced to or if it is getting old, failures only cost us the checkpoint.
+func (self *ScaleTarget) checkpoint(client *KubeClient, force bool) {
+	self.state.Replicas = self.currentReplicas
+	if needsCheckpoint(self.state, force) {
+		err := client.SaveState(self.name, self.state)
+		if err != nil {
+			glog.Errorf("Error saving state of %s: %s", self.name, err)
+		}
+	}
+}
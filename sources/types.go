@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"flag"
 
 	"encoding/json"
@@ -42,15 +43,17 @@ type Pod struct {
 	Status     string            `json:"status,omitempty"`
 	PodIP      string            `json:"podIP,omitempty"`
 	Labels     map[string]string `json:"labels,omitempty"`
+	Created    time.Time         `json:"created,omitempty"`
+	Ready      time.Time         `json:"ready,omitempty"` // when we first saw it ready
 }
 
 type Container interface {
 	GetName() string
-	CheckStats(kube *KubeSource) error
+	CheckStats(ctx context.Context, kube *KubeSource) error
 }
 
 type QueryEntry interface {
-	Calculate(client *KubeClient) error
+	Calculate(ctx context.Context, client *KubeClient) error
 }
 
 func newDmrContainer() *DmrContainer {
@@ -58,10 +61,36 @@ func newDmrContainer() *DmrContainer {
 }
 
 type Source interface {
-	CheckData() error
+	CheckData(ctx context.Context) error
+	// Close flushes the controller state and releases the leadership, on shutdown.
+	Close() error
 }
 
 func NewSource(d *time.Duration) (Source, error) {
+	if err := validateManualScalePolicy(); err != nil {
+		return nil, err
+	}
+	if err := validateConflictMode(); err != nil {
+		return nil, err
+	}
+	if err := validateRolloutMode(); err != nil {
+		return nil, err
+	}
+	if err := validateForecast(); err != nil {
+		return nil, err
+	}
+	if err := validatePolicy(); err != nil {
+		return nil, err
+	}
+	if err := validateShadowPolicies(); err != nil {
+		return nil, err
+	}
+	if err := validateFlapping(); err != nil {
+		return nil, err
+	}
+	if err := validateSafeMode(); err != nil {
+		return nil, err
+	}
 	if *sourceType == "k8s" {
 		return NewKubeSource(d)
 	} else if *sourceType == "influxdb" {
@@ -0,0 +1,97 @@
+package sources
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argTargetUtilization      = flag.Float64("target_utilization", 0.7, "Per pod utilization (rate / eap_pod_rate) the utilization policy aims at")
+	argUtilizationTolerance   = flag.Float64("utilization_tolerance", 0.1, "Relative deviation from the target utilization that is left alone")
+	argDownscaleStabilization = flag.Duration("downscale_stabilization", 5*time.Minute, "The utilization policy scales down to the highest replicas it recommended this long")
+)
+
+func validateUtilization() error {
+	if *argTargetUtilization <= 0 {
+		return fmt.Errorf("Invalid target_utilization %v, it must be positive", *argTargetUtilization)
+	}
+	if *argUtilizationTolerance < 0 {
+		return fmt.Errorf("Invalid utilization_tolerance %v", *argUtilizationTolerance)
+	}
+	return nil
+}
+
+// Recommendation is what the utilization policy asked for on a poll, checkpointed so a restart does
+// not shorten the scale down stabilization.
+type Recommendation struct {
+	At       time.Time `json:"at"`
+	Replicas int       `json:"replicas"`
+}
+
+// UtilizationPolicy computes replicas like the Kubernetes horizontal pod autoscaler does for a
+// resource target: ceil(pods x utilization / target), unless within the tolerance.
+type UtilizationPolicy struct {
+	recommendations []Recommendation // for the scale down stabilization
+}
+
+func newUtilizationPolicy(recommendations []Recommendation) *UtilizationPolicy {
+	return &UtilizationPolicy{recommendations: recommendations}
+}
+
+// desired follows the HPA: pods without metrics count as idle on a scale up and as fully used on a scale
+// down, so they never make the decision more drastic, and a decision they would reverse is not made.
+func (self *UtilizationPolicy) desired(observation Observation) int {
+	current := observation.Replicas
+	if observation.Pods == 0 {
+		return current // no metrics, no change
+	}
+
+	utilization := observation.Rate / float64(observation.Pods**eapPodRate)
+	ratio := utilization / *argTargetUtilization
+	if math.Abs(ratio-1) <= *argUtilizationTolerance {
+		return current
+	}
+
+	missing := current - observation.Pods
+	if missing <= 0 {
+		return int(math.Ceil(ratio * float64(observation.Pods)))
+	}
+
+	total := observation.Rate / float64(*eapPodRate)
+	if ratio < 1 {
+		total += float64(missing) // fully used
+	}
+	adjusted := total / float64(current) / *argTargetUtilization
+	if math.Abs(adjusted-1) <= *argUtilizationTolerance || (ratio > 1) != (adjusted > 1) {
+		return current
+	}
+	return int(math.Ceil(adjusted * float64(current)))
+}
+
+func (self *UtilizationPolicy) Replicas(observation Observation) int {
+	replicas := clampReplicas(self.desired(observation))
+
+	// scale down to the highest recommendation of the stabilization window, like the HPA
+	self.recommendations = append(self.recommendations, Recommendation{At: observation.At, Replicas: replicas})
+	stabilized := replicas
+	kept := self.recommendations[:0]
+	for _, previous := range self.recommendations {
+		if observation.At.Sub(previous.At) > *argDownscaleStabilization {
+			continue
+		}
+		kept = append(kept, previous)
+		if previous.Replicas > stabilized {
+			stabilized = previous.Replicas
+		}
+	}
+	self.recommendations = kept
+
+	if stabilized != replicas {
+		glog.V(1).Infof("Utilization policy recommends %v replicas, stabilized at %v", replicas, stabilized)
+	}
+	return stabilized
+}
@@ -0,0 +1,175 @@
+package sources
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	argPolicyWebhook         = flag.String("policy_webhook", "", "URL the webhook policy POSTs the metrics of a target to, answering the replicas")
+	argPolicyWebhookTimeout  = flag.Duration("policy_webhook_timeout", 2*time.Second, "How long to wait for the policy webhook before falling back")
+	argPolicyWebhookFallback = flag.String("policy_webhook_fallback", "step", "Policy used when the webhook fails: step, utilization, rules, expression or pid")
+	argPolicyWebhookHistory  = flag.Duration("policy_webhook_history", 10*time.Minute, "How much observation history is sent to the webhook")
+	argPolicyWebhookCooldown = flag.Duration("policy_webhook_cooldown", 0, "Minimum time between replica changes asked for by the webhook")
+)
+
+func validateWebhook() error {
+	target, err := url.Parse(*argPolicyWebhook)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return fmt.Errorf("Invalid policy_webhook: %q", *argPolicyWebhook)
+	}
+	if *argPolicyWebhookTimeout <= 0 {
+		return fmt.Errorf("Invalid policy_webhook_timeout %v", *argPolicyWebhookTimeout)
+	}
+	if *argPolicyWebhookFallback == "webhook" {
+		return fmt.Errorf("The webhook policy cannot fall back to itself")
+	}
+	return validatePolicyKind(*argPolicyWebhookFallback)
+}
+
+// PodObservation is what was observed of a single pod.
+type PodObservation struct {
+	Name    string  `json:"name"`
+	Rate    float64 `json:"rate"`
+	Latency float64 `json:"latency"`
+	Heap    float64 `json:"heapUsedPct,omitempty"`
+}
+
+// AggregateObservation is an observation of all pods of a target, as sent to the webhook.
+type AggregateObservation struct {
+	Time        time.Time `json:"time"`
+	Rate        float64   `json:"rate"`
+	Latency     float64   `json:"latency"`
+	Utilization float64   `json:"utilization"`
+	Heap        float64   `json:"heapUsedPct,omitempty"`
+	Pods        int       `json:"pods"`
+	Replicas    int       `json:"replicas"`
+}
+
+func aggregate(observation Observation) AggregateObservation {
+	return AggregateObservation{
+		Time:        observation.At,
+		Rate:        observation.Rate,
+		Latency:     observation.Latency,
+		Utilization: observation.Utilization(),
+		Heap:        observation.Heap,
+		Pods:        observation.Pods,
+		Replicas:    observation.Replicas,
+	}
+}
+
+// WebhookRequest is the metrics snapshot POSTed to the webhook.
+type WebhookRequest struct {
+	Target      string                 `json:"target"`
+	Replicas    int                    `json:"replicas"`
+	MinReplicas int                    `json:"minReplicas"`
+	MaxReplicas int                    `json:"maxReplicas"`
+	PodRate     int                    `json:"podRate"` // eap_pod_rate
+	Aggregate   AggregateObservation   `json:"aggregate"`
+	Pods        []PodObservation       `json:"pods"`
+	History     []AggregateObservation `json:"history"` // oldest first, without the current observation
+}
+
+// WebhookResponse is what the webhook answers.
+type WebhookResponse struct {
+	Replicas int    `json:"replicas"`
+	Reason   string `json:"reason"`
+}
+
+// WebhookPolicy asks an external service for the replicas. Its answer is clamped and rate limited by
+// the cooldown, and the fallback policy decides when the webhook fails.
+type WebhookPolicy struct {
+	owner    PolicyOwner
+	fallback Policy
+	client   *http.Client
+	history  []Observation
+	changed  time.Time // when the webhook last changed the replicas
+	reason   string    // of the last decision
+}
+
+func newWebhookPolicy(owner PolicyOwner, restored *ControllerState) *WebhookPolicy {
+	return &WebhookPolicy{
+		owner:    owner,
+		fallback: createPolicy(*argPolicyWebhookFallback, owner, restored),
+		client:   &http.Client{Timeout: *argPolicyWebhookTimeout},
+		changed:  restored.WebhookChanged,
+	}
+}
+
+func (self *WebhookPolicy) ask(observation Observation) (*WebhookResponse, error) {
+	request := WebhookRequest{
+		Target:      self.owner.name,
+		Replicas:    observation.Replicas,
+		MinReplicas: *minEapPods,
+		MaxReplicas: *maxEapPods,
+		PodRate:     *eapPodRate,
+		Aggregate:   aggregate(observation),
+		Pods:        observation.PerPod,
+		History:     make([]AggregateObservation, 0, len(self.history)),
+	}
+	if request.Pods == nil {
+		request.Pods = make([]PodObservation, 0)
+	}
+	for _, previous := range self.history {
+		request.History = append(request.History, aggregate(previous))
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.client.Post(*argPolicyWebhook, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Policy webhook answered %s", resp.Status)
+	}
+
+	response := &WebhookResponse{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid policy webhook answer: %s", err)
+	}
+	if response.Replicas < 0 {
+		return nil, fmt.Errorf("Policy webhook answered %v replicas", response.Replicas)
+	}
+	return response, nil
+}
+
+func (self *WebhookPolicy) Replicas(observation Observation) int {
+	fallback := self.fallback.Replicas(observation) // keeps the fallback's state current
+	response, err := self.ask(observation)
+
+	self.history = append(self.history, observation)
+	for len(self.history) > 0 && observation.At.Sub(self.history[0].At) > *argPolicyWebhookHistory {
+		self.history = self.history[1:]
+	}
+
+	if err != nil {
+		glog.Errorf("Error asking the policy webhook for %s, falling back to %s: %s", self.owner.name, *argPolicyWebhookFallback, err)
+		self.reason = fmt.Sprintf("fallback to %s: %s", *argPolicyWebhookFallback, err)
+		return fallback
+	}
+
+	replicas := clampReplicas(response.Replicas)
+	if replicas != observation.Replicas {
+		if observation.At.Sub(self.changed) < *argPolicyWebhookCooldown {
+			glog.Infof("Policy webhook asks for %v replicas of %s, cooling down", replicas, self.owner.name)
+			self.reason = fmt.Sprintf("cooling down: %s", response.Reason)
+			return observation.Replicas
+		}
+		self.changed = observation.At
+	}
+	glog.Infof("Policy webhook asks for %v replicas of %s: %s", replicas, self.owner.name, response.Reason)
+	self.reason = response.Reason
+	return replicas
+}